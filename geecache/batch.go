@@ -0,0 +1,330 @@
+package geecache
+
+import (
+	pb "Cache/geecache/geecachepb"
+	"Cache/geecache/singleflight"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+批量查询 一次获取多个 key
+1. 先查本地缓存和热点缓存
+2. 没命中的 key 按 PickPeer 选出的节点分组 每个远程节点只发一次 BatchRequest
+3. 剩下的 key 由本地加载 回调函数实现了 BatchGetter 时一次加载全部
+开启多副本时 主节点失败的 key 依次尝试其他副本 自己是主节点时本地加载的值推送给其他副本
+每个 key 的错误单独返回 部分 key 失败不影响其他 key 的结果
+*/
+
+// BatchGetter 是回调函数可选实现的接口 一次从数据源加载多个 key
+// 返回结果中没有的 key 视为不存在（ErrNotFound） 返回的 error 作用于所有 key
+// 实现了 BatchGetter 的回调函数仍需要实现 Getter 供 Get 使用
+type BatchGetter interface {
+	GetMulti(ctx context.Context, keys []string) (map[string][]byte, error)
+}
+
+// TTLBatchGetter 是 BatchGetter 的带 ttl 版本 优先于 BatchGetter 使用
+// ttls 中没有的 key 或 ttl <= 0 时使用 Group 的默认 ttl
+type TTLBatchGetter interface {
+	GetMultiWithTTL(ctx context.Context, keys []string) (values map[string][]byte, ttls map[string]time.Duration, err error)
+}
+
+// BatchPeerGetter 是 PeerGetter 可选实现的接口 一次请求从远程节点获取多个 key
+// out.Responses 与 in.Keys 一一对应 每个 key 的错误由 Response.Code 表示
+type BatchPeerGetter interface {
+	GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error
+}
+
+// MultiError 记录 GetMulti 中每个失败的 key 对应的错误
+type MultiError map[string]error
+
+func (e MultiError) Error() string {
+	keys := make([]string, 0, len(e))
+	for key := range e {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	msgs := make([]string, len(keys))
+	for i, key := range keys {
+		msgs[i] = e[key].Error()
+	}
+	return fmt.Sprintf("geecache: %d keys failed: %s", len(keys), strings.Join(msgs, "; "))
+}
+
+// GetMulti 与 GetMultiContext 相同 使用 context.Background()
+func (g *Group) GetMulti(keys []string) (map[string]ByteView, error) {
+	return g.GetMultiContext(context.Background(), keys)
+}
+
+// GetMultiContext 批量获取 keys 对应的缓存值 重复的 key 只查询一次
+// 返回成功的部分结果 有 key 失败时 error 为 MultiError
+func (g *Group) GetMultiContext(ctx context.Context, keys []string) (map[string]ByteView, error) {
+	b := &batch{
+		values: make(map[string]ByteView, len(keys)),
+		errs:   make(MultiError),
+	}
+	var misses []string
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		g.stats.Gets.Add(1)
+		if key == "" {
+			b.fail(key, fmt.Errorf("key is required"))
+			continue
+		}
+		if v, ok, err := g.lookupCache(key); ok {
+			b.set(key, v, err)
+			continue
+		}
+		misses = append(misses, key)
+	}
+
+	// 按节点分组 每个远程节点并发发送一次请求 失败后可以本地加载的 key 加入 local
+	local := misses
+	if g.peers != nil && len(misses) > 0 && !isForwarded(ctx) {
+		local = nil
+		remote := make(map[PeerGetter][]string)
+		var order []PeerGetter // 保持发送顺序稳定
+		for _, key := range misses {
+			peer, ok := g.peers.PickPeer(key)
+			if !ok {
+				local = append(local, key)
+				continue
+			}
+			if _, ok := remote[peer]; !ok {
+				order = append(order, peer)
+			}
+			remote[peer] = append(remote[peer], key)
+		}
+		var (
+			wg sync.WaitGroup
+			mu sync.Mutex
+		)
+		for _, peer := range order {
+			wg.Add(1)
+			go func(peer PeerGetter, keys []string) {
+				defer wg.Done()
+				fallback := g.getMultiFromPeer(ctx, peer, keys, b)
+				fallback = g.getMultiFromReplicas(ctx, peer, fallback, b)
+				mu.Lock()
+				local = append(local, fallback...)
+				mu.Unlock()
+			}(peer, remote[peer])
+		}
+		wg.Wait()
+	}
+
+	if len(local) > 0 {
+		if ctx.Err() != nil { // 调用方已经放弃 不必再从本地加载
+			for _, key := range local {
+				b.fail(key, ctx.Err())
+			}
+		} else {
+			g.getMultiLocally(ctx, local, b)
+		}
+	}
+	if len(b.errs) > 0 {
+		return b.values, b.errs
+	}
+	return b.values, nil
+}
+
+// batch 收集 GetMulti 的结果 多个节点的请求并发写入
+type batch struct {
+	mu     sync.Mutex
+	values map[string]ByteView
+	errs   MultiError
+}
+
+func (b *batch) set(key string, value ByteView, err error) {
+	if err != nil {
+		b.fail(key, err)
+		return
+	}
+	b.mu.Lock()
+	b.values[key] = value
+	b.mu.Unlock()
+}
+
+func (b *batch) fail(key string, err error) {
+	b.mu.Lock()
+	b.errs[key] = err
+	b.mu.Unlock()
+}
+
+// 从一个远程节点批量获取 keys 结果写入 b 返回需要改为本地加载的 key
+// 远程节点没有实现 BatchPeerGetter 时逐个 key 请求
+func (g *Group) getMultiFromPeer(ctx context.Context, peer PeerGetter, keys []string, b *batch) (fallback []string) {
+	bp, ok := peer.(BatchPeerGetter)
+	if !ok {
+		for _, key := range keys {
+			value, err := g.getFromPeer(ctx, peer, key)
+			if g.peerResult(key, value, err, b) {
+				fallback = append(fallback, key)
+			}
+		}
+		return fallback
+	}
+
+	req := &pb.BatchRequest{Group: g.name, Keys: keys, Hops: 1}
+	res := &pb.BatchResponse{}
+	err := g.callPeer(ctx, peer, func() error {
+		res.Reset()
+		return bp.GetMulti(ctx, req, res)
+	})
+	if err == nil && len(res.Responses) != len(keys) {
+		err = fmt.Errorf("batch response has %d values for %d keys", len(res.Responses), len(keys))
+	}
+	if err != nil { // 整个请求失败 每个 key 按相同的错误处理
+		g.stats.PeerErrors.Add(int64(len(keys)))
+		log.Println("[GeeCache] Failed to get batch from peer", err)
+		for _, key := range keys {
+			if !shouldFallback(err) {
+				b.fail(key, err)
+				continue
+			}
+			fallback = append(fallback, key)
+		}
+		return fallback
+	}
+	for i, key := range keys {
+		r := res.Responses[i]
+		if r.Code != pb.Code_OK {
+			err = &PeerError{Code: r.Code, Message: r.Message}
+		} else {
+			err = nil
+		}
+		var value ByteView
+		if err == nil {
+			value = g.peerValue(key, r)
+		}
+		if g.peerResult(key, value, err, b) {
+			fallback = append(fallback, key)
+		}
+	}
+	return fallback
+}
+
+// 记录一个 key 从远程节点获取的结果 返回是否需要改为本地加载
+func (g *Group) peerResult(key string, value ByteView, err error, b *batch) bool {
+	if err == nil || codeOf(err) == pb.Code_NOT_FOUND {
+		g.stats.PeerLoads.Add(1)
+		b.set(key, value, err)
+		return false
+	}
+	g.stats.PeerErrors.Add(1)
+	if !shouldFallback(err) {
+		b.fail(key, err)
+		return false
+	}
+	return true
+}
+
+// 从本地加载 keys 通过 singleflight 与 Get 共享正在进行的加载
+// 其余的 key 一起交给 loadMulti 回调函数实现了 BatchGetter 时只调用一次
+func (g *Group) getMultiLocally(ctx context.Context, keys []string, b *batch) {
+	flights := make(map[string]string, len(keys)) // singleflight 使用的 key -> key
+	fkeys := make([]string, len(keys))
+	for i, key := range keys {
+		fkeys[i] = flightKey(ctx, key)
+		flights[fkeys[i]] = key
+	}
+	results := g.loader.DoMulti(ctx, fkeys, func(ctx context.Context, fkeys []string) map[string]singleflight.Result {
+		keys := make([]string, len(fkeys))
+		for i, fkey := range fkeys {
+			keys[i] = flights[fkey]
+		}
+		loaded := g.loadMulti(ctx, keys)
+		results := make(map[string]singleflight.Result, len(fkeys))
+		for _, fkey := range fkeys {
+			results[fkey] = loaded[flights[fkey]]
+		}
+		return results
+	})
+	for fkey, r := range results {
+		key := flights[fkey]
+		if r.Shared {
+			g.stats.Dedups.Add(1)
+		}
+		if r.Err != nil {
+			b.fail(key, r.Err)
+			continue
+		}
+		value := r.Val.(ByteView)
+		b.set(key, value, nil)
+		if replicas := g.replicas(key); !r.Shared && replicas != nil && replicas[0] == nil { // 共享的加载由发起方推送
+			g.pushToReplicas(key, value, replicas[1:])
+		}
+	}
+}
+
+// 从数据源加载 keys 与 getLoacally 一样统计并放入缓存
+// 回调函数实现了 TTLBatchGetter 或 BatchGetter 时只调用一次 否则逐个 key 加载
+// 同时实现了 TTLGetter 的回调函数需要实现 TTLBatchGetter 才会批量加载 否则会丢失每个 key 的 ttl
+func (g *Group) loadMulti(ctx context.Context, keys []string) map[string]singleflight.Result {
+	results := make(map[string]singleflight.Result, len(keys))
+	var (
+		values map[string][]byte
+		ttls   map[string]time.Duration
+		err    error
+	)
+	if getter, ok := g.getter.(TTLBatchGetter); ok {
+		values, ttls, err = getter.GetMultiWithTTL(ctx, keys)
+	} else if getter, ok := g.getter.(BatchGetter); ok && !hasTTL(g.getter) {
+		values, err = getter.GetMulti(ctx, keys)
+	} else {
+		for _, key := range keys {
+			value, err := g.getLoacally(ctx, key)
+			results[key] = singleflight.Result{Val: value, Err: err}
+		}
+		return results
+	}
+	for _, key := range keys {
+		bytes, ok := values[key]
+		keyErr := err
+		if keyErr == nil && !ok {
+			keyErr = notFoundError(key) // 返回结果中没有的 key 视为不存在
+		}
+		value, keyErr := g.loaded(key, bytes, ttls[key], keyErr)
+		results[key] = singleflight.Result{Val: value, Err: keyErr}
+	}
+	return results
+}
+
+// 回调函数是否能为每个 key 单独指定 ttl
+func hasTTL(getter Getter) bool {
+	switch getter.(type) {
+	case TTLGetter, TTLGetterContext:
+		return true
+	}
+	return false
+}
+
+// 服务端处理 BatchRequest Responses 与 keys 一一对应 返回失败的 key 的个数
+func serveBatch(ctx context.Context, group *Group, keys []string) (*pb.BatchResponse, int) {
+	values, err := group.GetMultiContext(ctx, keys)
+	errs, _ := err.(MultiError)
+	res := &pb.BatchResponse{Responses: make([]*pb.Response, len(keys))}
+	failed := 0
+	for i, key := range keys {
+		if err, ok := errs[key]; ok {
+			code := codeOf(err)
+			if code != pb.Code_NOT_FOUND { // 不存在是正常的查询结果
+				failed++
+			}
+			res.Responses[i] = &pb.Response{Code: code, Message: err.Error()}
+			continue
+		}
+		view := values[key]
+		res.Responses[i] = &pb.Response{Value: view.ByteSlice(), Expire: view.expireUnixNano()}
+	}
+	return res, failed
+}
@@ -0,0 +1,156 @@
+package geecache
+
+import (
+	pb "Cache/geecache/geecachepb"
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+/*
+远程请求的熔断与重试
+熔断器按远程节点分别统计 连续失败 threshold 次后打开 打开期间不再请求该节点 直接本地加载
+cooldown 之后进入半开状态 只放行一个请求试探 成功则关闭 失败则重新打开
+重试只针对暂时性的错误（网络错误 超时 节点不可用） 每次重试前等待带随机抖动的指数退避时间
+*/
+
+// ErrCircuitOpen 表示远程节点的熔断器处于打开状态 请求没有发出
+var ErrCircuitOpen = errors.New("geecache: circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // 正常放行
+	breakerOpen                         // 拒绝所有请求
+	breakerHalfOpen                     // 只放行一个试探请求
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// breaker 是单个远程节点的熔断器
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     breakerState
+	failures  int       // 关闭状态下连续失败的次数
+	openedAt  time.Time // 最近一次打开的时间
+	probing   bool      // 半开状态下是否已有试探请求
+}
+
+// 是否允许发出请求 允许时调用方必须随后调用 done
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+	default:
+		return true
+	}
+	b.probing = true
+	return true
+}
+
+// 请求被调用方取消 结果不能说明节点是否正常 只结束试探 不改变状态
+func (b *breaker) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// 记录请求的结果 failed 为 true 表示节点故障
+func (b *breaker) done(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !failed {
+		b.state, b.failures = breakerClosed, 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state, b.openedAt = breakerOpen, time.Now()
+	}
+}
+
+// 返回 peer 对应的熔断器 没有开启熔断时返回 nil
+func (g *Group) breaker(peer PeerGetter) *breaker {
+	if g.breakerThreshold <= 0 {
+		return nil
+	}
+	if b, ok := g.breakers.Load(peer); ok {
+		return b.(*breaker)
+	}
+	b, _ := g.breakers.LoadOrStore(peer, &breaker{threshold: g.breakerThreshold, cooldown: g.breakerCooldown})
+	return b.(*breaker)
+}
+
+// 是否为暂时性的节点故障 远程节点返回的 NOT_FOUND INTERNAL 等错误说明节点是正常的
+func peerFailed(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var perr *PeerError
+	if errors.As(err, &perr) {
+		return perr.Code == pb.Code_UNAVAILABLE || perr.Code == pb.Code_DEADLINE_EXCEEDED
+	}
+	return true
+}
+
+// 经过熔断器调用 call 暂时性的错误按 WithRetry 的配置重试
+func (g *Group) callPeer(ctx context.Context, peer PeerGetter, call func() error) error {
+	b := g.breaker(peer)
+	for attempt := 0; ; attempt++ {
+		if b != nil && !b.allow() {
+			g.stats.BreakerRejects.Add(1)
+			return ErrCircuitOpen
+		}
+		err := call()
+		failed := peerFailed(err)
+		if b != nil {
+			if ctx.Err() == context.Canceled || errors.Is(err, context.Canceled) {
+				b.cancel() // 调用方取消了请求 不计入熔断统计
+			} else {
+				b.done(failed)
+			}
+		}
+		if !failed || attempt >= g.retries || ctx.Err() != nil {
+			return err
+		}
+		g.stats.PeerRetries.Add(1)
+		select {
+		case <-time.After(backoff(g.retryBackoff, attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// 第 attempt 次重试前的等待时间 在 base*2^attempt 的一半到全部之间随机
+func backoff(base time.Duration, attempt int) time.Duration {
+	d := base << uint(attempt)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
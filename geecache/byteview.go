@@ -1,9 +1,27 @@
 package geecache
 
+import "time"
+
 // 此部分负责缓存值的抽象和封装
 // 只读数据结构 ByteView 用来表示缓存值
 type ByteView struct {
 	b []byte // 真实的缓存值 选择byte类型是为了支持任意的数据类型存储 如字符 图片等
+	e time.Time // 过期时间 零值表示永不过期
+	// 缓存的是"不存在"的结果 只在 Group 内部使用 不会返回给调用方
+	notFound bool
+}
+
+// Expire 返回缓存值的过期时间 零值表示永不过期
+func (v ByteView) Expire() time.Time {
+	return v.e
+}
+
+// 过期时间的 unix 纳秒表示 用于在节点间传递 0 表示永不过期
+func (v ByteView) expireUnixNano() int64 {
+	if v.e.IsZero() {
+		return 0
+	}
+	return v.e.UnixNano()
 }
 
 func (v ByteView) Len() int {
@@ -25,4 +43,4 @@ func cloneBytes(b []byte) []byte {
 
 func (v ByteView) String() string {
 	return string(v.b)
-}
\ No newline at end of file
+}
@@ -3,12 +3,49 @@ package geecache
 import (
 	"Cache/lru"
 	"sync"
+	"time"
 )
+
+// 后台清理过期数据的默认间隔
+const defaultSweepInterval = time.Minute
+
+// store 是 Group 的 mainCache 需要的操作 cache 和 shardedCache 都实现了它
+type store interface {
+	add(key string, value ByteView)
+	get(key string) (value ByteView, ok bool)
+	remove(key string) bool
+	purge()
+	stats() CacheStats
+	close() // 停止后台清理协程
+}
+
+// cacheConfig 是 cache 的可选配置 由 GroupOption 设置 mainCache 的每个分片和 hotCache 共用
+type cacheConfig struct {
+	newPolicy     lru.Factory                                              // 淘汰策略 nil 表示使用 lru.LRU
+	sweepInterval time.Duration                                            // 后台清理过期数据的间隔 <=0 表示只做惰性过期
+	onEvicted     func(key string, value ByteView, reason lru.EvictReason) // 记录被移除时的回调 可为nil
+	staleFor      time.Duration                                            // 过期后继续保留的时间 期间可以返回旧值 见 WithStaleWhileRevalidate
+}
+
 // 此部分负责并发控制
 type cache struct {
+	cacheConfig
 	mu sync.Mutex
-	lru *lru.Cache
+	lru lru.Policy
 	cacheBytes int64 // maxBytes 允许的最大内存
+	sweeping bool // 后台清理协程是否已启动
+	stop chan struct{} // 关闭后后台清理协程退出 见 close
+	closed bool // 调用过 close 之后不再启动后台清理协程
+	nget, nhit int64 // 统计 get 次数和命中次数
+	nevict int64 // 统计因容量不足或过期被淘汰的次数
+	pending []evictedEntry // 持有 mu 时被移除的记录 释放 mu 之后再交给 onEvicted
+}
+
+// 一条被移除的记录 等待调用 onEvicted
+type evictedEntry struct {
+	key    string
+	value  ByteView
+	reason lru.EvictReason
 }
 /*
 实例化lru
@@ -16,21 +53,126 @@ type cache struct {
  */
 func (c *cache) add(key string, value ByteView) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 	if c.lru == nil { // 这种叫 延迟初始化  主要用于提高性能 减少程序内存的要求
-		c.lru = lru.New(c.cacheBytes, nil) // 实例化lru
+		newPolicy := c.newPolicy
+		if newPolicy == nil {
+			newPolicy = lru.LRU
+		}
+		c.lru = newPolicy(c.cacheBytes, c.evicted) // 实例化lru
+	}
+	expire := value.Expire()
+	// 逻辑上的过期时间仍由 value.Expire() 判断 "不存在"的结果不保留旧值
+	if !expire.IsZero() && c.staleFor > 0 && !value.notFound {
+		expire = expire.Add(c.staleFor)
+	}
+	c.lru.AddWithExpire(key, value, expire)
+	// 第一次出现会过期的数据时 才启动后台清理协程 同样是延迟初始化
+	if !value.Expire().IsZero() && !c.sweeping && !c.closed && c.sweepInterval > 0 {
+		c.sweeping = true
+		c.stop = make(chan struct{})
+		go c.sweep(c.sweepInterval, c.stop)
 	}
-	c.lru.Add(key, value)
 }
 
 func (c *cache) get(key string) (value ByteView, ok bool ){
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
+	c.nget++
 	if c.lru == nil {
 		return
 	}
 	if v, ok := c.lru.Get(key); ok {
+		c.nhit++
 		return v.(ByteView), ok
 	}
 	return
-}
\ No newline at end of file
+}
+
+// 删除 key 返回 key 是否存在
+func (c *cache) remove(key string) bool {
+	c.mu.Lock()
+	defer c.unlock()
+	if c.lru == nil {
+		return false
+	}
+	return c.lru.Remove(key)
+}
+
+// 清空缓存
+func (c *cache) purge() {
+	c.mu.Lock()
+	defer c.unlock()
+	if c.lru == nil {
+		return
+	}
+	c.lru.Purge()
+}
+
+// 返回统计信息的快照
+func (c *cache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := CacheStats{Gets: c.nget, Hits: c.nhit, Evictions: c.nevict}
+	if c.lru != nil {
+		s.Bytes = c.lru.Bytes()
+		s.Items = int64(c.lru.Len())
+	}
+	return s
+}
+
+// 将 lru 的回调转换为 ByteView 类型 暂存起来 由 unlock 转发给 onEvicted
+// 由 lru 在持有 c.mu 时调用 此时直接调用 onEvicted 回调里再访问 Group 会死锁
+func (c *cache) evicted(key string, value lru.Value, reason lru.EvictReason) {
+	if reason == lru.EvictCapacity || reason == lru.EvictExpired {
+		c.nevict++
+	}
+	if c.onEvicted != nil {
+		c.pending = append(c.pending, evictedEntry{key, value.(ByteView), reason})
+	}
+}
+
+// 释放 c.mu 然后调用期间积累的 onEvicted 回调
+// 回调中可以安全地调用 Group 的 Get Remove 等方法
+func (c *cache) unlock() {
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	for _, e := range pending {
+		c.onEvicted(e.key, e.value, e.reason)
+	}
+}
+
+// 定期清理已过期但一直没有被访问的数据 stop 关闭后退出
+func (c *cache) sweep(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.removeExpired()
+		}
+	}
+}
+
+// 清理已过期的数据
+func (c *cache) removeExpired() {
+	c.mu.Lock()
+	defer c.unlock()
+	if c.lru != nil {
+		c.lru.RemoveExpired()
+	}
+}
+
+// 停止后台清理协程 之后过期数据只在 get 时惰性清理 可以重复调用
+func (c *cache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sweeping {
+		close(c.stop)
+		c.sweeping = false
+	}
+	c.closed = true
+}
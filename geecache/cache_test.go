@@ -0,0 +1,144 @@
+package geecache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestShardedCache(t *testing.T) {
+	s := newShardedCache(4, 4*int64(len("key0value")), cacheConfig{})
+	for i := 0; i < 100; i++ {
+		s.add("key"+strconv.Itoa(i%10), ByteView{b: []byte("value")})
+	}
+	for _, c := range s.shards {
+		if c.cacheBytes != int64(len("key0value")) {
+			t.Fatalf("each shard should get 1/4 of cacheBytes, got %d", c.cacheBytes)
+		}
+		if st := c.stats(); st.Bytes > c.cacheBytes {
+			t.Fatalf("shard uses %d bytes over its budget %d", st.Bytes, c.cacheBytes)
+		}
+	}
+	st := s.stats()
+	if st.Items == 0 || st.Items > 4 || st.Evictions == 0 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	for i := 0; i < 10; i++ {
+		key := "key" + strconv.Itoa(i)
+		if v, ok := s.get(key); ok && v.String() != "value" {
+			t.Fatalf("%s: unexpected value %s", key, v)
+		}
+		s.remove(key)
+	}
+	if st := s.stats(); st.Items != 0 || st.Bytes != 0 {
+		t.Fatalf("all keys should be removed, got %+v", st)
+	}
+}
+
+func TestShardedCacheBytes(t *testing.T) {
+	s := newShardedCache(4, 10, cacheConfig{})
+	total := int64(0)
+	for _, c := range s.shards {
+		total += c.cacheBytes
+	}
+	if total != 10 || s.shards[0].cacheBytes != 3 || s.shards[3].cacheBytes != 2 {
+		t.Fatalf("remainder should be spread over the first shards, got total %d", total)
+	}
+	s = newShardedCache(8, 3, cacheConfig{})
+	for i, c := range s.shards {
+		if c.cacheBytes < 1 {
+			t.Fatalf("shard %d has no byte limit", i)
+		}
+	}
+}
+
+// 所有分片共用一个清理协程 close 后退出
+func TestShardedCacheSweep(t *testing.T) {
+	s := newShardedCache(4, 0, cacheConfig{sweepInterval: time.Millisecond})
+	for i := 0; i < 20; i++ {
+		s.add("key"+strconv.Itoa(i), ByteView{b: []byte("v"), e: time.Now().Add(time.Millisecond)})
+	}
+	for _, c := range s.shards {
+		if c.sweeping {
+			t.Fatal("shards should not start their own sweeper")
+		}
+	}
+	waitFor(t, func() bool { return s.stats().Items == 0 })
+	s.close()
+	s.close() // 可以重复调用
+	select {
+	case <-s.stop:
+	default:
+		t.Fatal("close should stop the sweeper")
+	}
+}
+
+func TestWithShards(t *testing.T) {
+	gee := NewGroup("shards", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}), WithShards(8))
+	if s, ok := gee.mainCache.(*shardedCache); !ok || len(s.shards) != 8 {
+		t.Fatal("mainCache should have 8 shards")
+	}
+	for _, key := range []string{"Tom", "Jack", "Sam", "Tom"} {
+		if v, err := gee.Get(key); err != nil || v.String() != key {
+			t.Fatalf("failed to get %s", key)
+		}
+	}
+	if s := gee.Stats(); s.Hits != 1 || s.Items != 3 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestCacheClose(t *testing.T) {
+	c := &cache{cacheConfig: cacheConfig{sweepInterval: time.Millisecond}}
+	c.add("k", ByteView{b: []byte("v"), e: time.Now().Add(5 * time.Millisecond)})
+	stop := c.stop
+	if !c.sweeping || stop == nil {
+		t.Fatal("sweeper should start after adding an expiring value")
+	}
+	c.close()
+	select {
+	case <-stop:
+	default:
+		t.Fatal("close should stop the sweeper")
+	}
+	c.add("k2", ByteView{b: []byte("v"), e: time.Now().Add(time.Hour)})
+	if c.sweeping {
+		t.Fatal("sweeper should not restart after close")
+	}
+	c.close() // 可以重复调用
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := c.get("k"); ok {
+		t.Fatal("expired value should still be removed lazily")
+	}
+}
+
+// 比较单锁 cache 和 shardedCache 在并发 Get 下的性能
+// go test -bench CacheGet -cpu 1,8,32
+const benchKeys = 1 << 10
+
+func benchmarkGet(b *testing.B, s store) {
+	keys := make([]string, benchKeys)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		s.add(keys[i], ByteView{b: []byte("value")})
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			s.get(keys[i%benchKeys])
+			i++
+		}
+	})
+}
+
+func BenchmarkCacheGet(b *testing.B) {
+	benchmarkGet(b, &cache{})
+}
+
+func BenchmarkShardedCacheGet(b *testing.B) {
+	benchmarkGet(b, newShardedCache(32, 0, cacheConfig{}))
+}
@@ -0,0 +1,72 @@
+package consistenthash
+
+import "math"
+
+/*
+有界负载的一致性哈希 https://arxiv.org/abs/1608.01350
+记录每个真实节点正在处理的请求数 每个节点最多处理 (1+ε) 倍的平均负载（按权重）
+负责 key 的节点已满时 沿顺时针方向交给下一个未满的节点
+热点 key 不会把一个节点压垮 负载降下来后 key 回到原来的节点
+*/
+
+// SetLoadBound 开启有界负载 epsilon <= 0 时关闭 此时 GetLeast 与 Get 相同
+// epsilon 越小负载越均匀 但越多的 key 会离开原来的节点
+func (m *Map) SetLoadBound(epsilon float64) {
+	m.epsilon = epsilon
+	if m.loads == nil {
+		m.loads = make(map[string]int)
+	}
+}
+
+// Inc 记录 node 开始处理一个请求
+func (m *Map) Inc(node string) {
+	if _, ok := m.weights[node]; !ok || m.loads == nil {
+		return
+	}
+	m.loads[node]++
+	m.totalLoad++
+}
+
+// Done 记录 node 处理完一个请求 与 Inc 成对调用
+func (m *Map) Done(node string) {
+	if m.loads[node] <= 0 { // 节点在请求期间被删除或重新添加过
+		return
+	}
+	m.loads[node]--
+	m.totalLoad--
+}
+
+// Load 返回 node 正在处理的请求数
+func (m *Map) Load(node string) int {
+	return m.loads[node]
+}
+
+// 再分配一个请求后 node 最多可以处理的请求数
+func (m *Map) capacity(node string) int {
+	avg := float64(m.totalLoad+1) * float64(m.weights[node]) / float64(m.total)
+	return int(math.Ceil(avg * (1 + m.epsilon)))
+}
+
+// GetLeast 从负责 key 的节点开始顺时针查找 返回第一个未满的真实节点
+// 所有节点的容量之和大于总负载 所以总能找到
+func (m *Map) GetLeast(key string) string {
+	if len(m.keys) == 0 {
+		return ""
+	}
+	start := m.search(int(m.hash([]byte(key))))
+	if m.epsilon <= 0 {
+		return m.hashMap[m.keys[start]]
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < len(m.keys); i++ {
+		node := m.hashMap[m.keys[(start+i)%len(m.keys)]]
+		if seen[node] {
+			continue
+		}
+		seen[node] = true
+		if m.loads[node] < m.capacity(node) {
+			return node
+		}
+	}
+	return m.hashMap[m.keys[start]]
+}
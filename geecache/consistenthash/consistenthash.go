@@ -16,6 +16,12 @@ type Map struct {
 	keys     []int          // 哈希环 keys
 	hashMap  map[int]string // 虚拟节点和真实节点的映射表 hashMap
 	// 键是虚拟节点的哈希值 值是真实节点的名称
+	weights map[string]int // 真实节点的权重 节点拥有 replicas*weight 个虚拟节点
+	total   int            // 所有真实节点的权重之和
+	// 有界负载模式 见 bounded.go
+	epsilon   float64
+	loads     map[string]int // 真实节点正在处理的请求数
+	totalLoad int
 }
 
 // New() 允许自定义虚拟节点倍数 和 Hash 函数
@@ -24,6 +30,7 @@ func New(replicas int, fn Hash) *Map {
 		replicas: replicas,
 		hash:     fn,
 		hashMap:  make(map[int]string),
+		weights:  make(map[string]int),
 	}
 	if m.hash == nil {
 		m.hash = crc32.ChecksumIEEE
@@ -42,16 +49,72 @@ func New(replicas int, fn Hash) *Map {
 */
 func (m *Map) Add(keys ...string) {
 	for _, key := range keys {
-		for i := 0; i < m.replicas; i++ {
-			hash := int(m.hash([]byte(strconv.Itoa(i) + key))) // 虚拟节点的哈希值
-			m.keys = append(m.keys, hash)
-			m.hashMap[hash] = key
-		}
+		m.AddWeighted(key, 1)
+	}
+}
+
+// AddWeighted 添加一个权重为 weight 的真实节点 即 replicas*weight 个虚拟节点
+// 机器配置不同时 权重越大的节点分到的 key 越多
+// 节点已存在时按新的权重重新添加
+func (m *Map) AddWeighted(node string, weight int) {
+	if weight <= 0 {
+		return
+	}
+	if _, ok := m.weights[node]; ok {
+		m.Remove(node)
+	}
+	m.weights[node] = weight
+	m.total += weight
+	for i := 0; i < m.replicas*weight; i++ {
+		hash := int(m.hash([]byte(strconv.Itoa(i) + node))) // 虚拟节点的哈希值
+		m.keys = append(m.keys, hash)
+		m.hashMap[hash] = node
 	}
 	sort.Ints(m.keys) // 排序
 	// fmt.Println("keys" + " hash值", m.keys)
 }
 
+// Weight 返回 node 的权重 node 不在环上时返回 0
+func (m *Map) Weight(node string) int {
+	return m.weights[node]
+}
+
+// Remove 从环上删除真实节点及其所有虚拟节点
+// 只有原本属于该节点的 key 会移动到顺时针的下一个节点 其他 key 不受影响
+func (m *Map) Remove(node string) {
+	weight, ok := m.weights[node]
+	if !ok {
+		return
+	}
+	delete(m.weights, node)
+	m.total -= weight
+	m.totalLoad -= m.loads[node]
+	delete(m.loads, node)
+	for i := 0; i < m.replicas*weight; i++ {
+		hash := int(m.hash([]byte(strconv.Itoa(i) + node)))
+		if m.hashMap[hash] == node { // 哈希冲突时虚拟节点可能已被其他节点覆盖
+			delete(m.hashMap, hash)
+		}
+	}
+	keys := m.keys[:0]
+	for _, hash := range m.keys {
+		if _, ok := m.hashMap[hash]; ok {
+			keys = append(keys, hash)
+		}
+	}
+	m.keys = keys
+}
+
+// Nodes 返回环上所有的真实节点 按名称排序
+func (m *Map) Nodes() []string {
+	nodes := make([]string, 0, len(m.weights))
+	for node := range m.weights {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
 // 实现选择节点的 Get() 方法
 /*
 1. 计算 key 的哈希值
@@ -65,13 +128,69 @@ func (m *Map) Get(key string) string {
 		return ""
 	}
 	hash := int(m.hash([]byte(key)))
+	// fmt.Println("key为", key, "idx为",idx, "hash值对应的真实节点为", m.hashMap[m.keys[idx%len(m.keys)]])
+	// hashMap 是存储 虚拟节点hash与真实的映射  keys 存储的是虚拟节点的hash
+
+	return m.hashMap[m.keys[m.search(hash)]]
+}
+
+// GetN 返回顺时针方向上负责 key 的前 n 个不同的真实节点 第一个与 Get 的结果相同
+// 真实节点不足 n 个时返回全部节点 可用于选择副本或备用节点
+func (m *Map) GetN(key string, n int) []string {
+	if len(m.keys) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(m.weights) {
+		n = len(m.weights)
+	}
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	start := m.search(int(m.hash([]byte(key))))
+	for i := 0; i < len(m.keys) && len(nodes) < n; i++ {
+		node := m.hashMap[m.keys[(start+i)%len(m.keys)]]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// 顺时针找到第一个哈希值 >= hash 的虚拟节点 返回其在 m.keys 中的下标
+// 调用方需保证 m.keys 不为空
+func (m *Map) search(hash int) int {
 	idx := sort.Search(len(m.keys), func(i int) bool { // 二分查找法 寻找满足函数的 最小索引
 		return m.keys[i] >= hash
 	})
-	// fmt.Println("key为", key, "idx为",idx, "hash值对应的真实节点为", m.hashMap[m.keys[idx%len(m.keys)]])
-	// hashMap 是存储 虚拟节点hash与真实的映射  keys 存储的是虚拟节点的hash
+	return idx % len(m.keys)
+}
 
-	return m.hashMap[m.keys[idx%len(m.keys)]]
+// Clone 返回 Map 的拷贝 之后对任一个的修改不会影响另一个
+func (m *Map) Clone() *Map {
+	c := &Map{
+		hash:      m.hash,
+		replicas:  m.replicas,
+		keys:      make([]int, len(m.keys)),
+		hashMap:   make(map[int]string, len(m.hashMap)),
+		weights:   make(map[string]int, len(m.weights)),
+		total:     m.total,
+		epsilon:   m.epsilon,
+		totalLoad: m.totalLoad,
+	}
+	copy(c.keys, m.keys)
+	for k, v := range m.hashMap {
+		c.hashMap[k] = v
+	}
+	for k, v := range m.weights {
+		c.weights[k] = v
+	}
+	if m.loads != nil {
+		c.loads = make(map[string]int, len(m.loads))
+		for k, v := range m.loads {
+			c.loads[k] = v
+		}
+	}
+	return c
 }
 
 /*
@@ -2,6 +2,8 @@ package consistenthash
 
 import (
 	"fmt"
+	"math"
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -47,4 +49,186 @@ func TestHashing(t *testing.T) {
 		}
 	}
 
-}
\ No newline at end of file
+}
+
+// 新增节点 8 之后 28 落在节点 8 的虚拟节点 28 上
+func TestHashingAddNode(t *testing.T) {
+	hash := New(3, numberHash)
+	hash.Add("6", "4", "2")
+	if hash.Get("28") != "2" {
+		t.Fatalf("Asking for 28, should have yielded 2")
+	}
+	hash.Add("8")
+	if hash.Get("28") != "8" {
+		t.Errorf("Asking for 28, should have yielded 8")
+	}
+}
+
+// 自定义的 Hash 只处理数字 与 TestHashing 相同
+func numberHash(key []byte) uint32 {
+	i, _ := strconv.Atoi(string(key))
+	return uint32(i)
+}
+
+func TestRemove(t *testing.T) {
+	hash := New(3, numberHash)
+	hash.Add("6", "4", "2")
+	hash.Add("8")
+	hash.Remove("8")
+	hash.Remove("9") // 不存在的节点
+
+	// 删除节点 8 之后 与只添加 2/4/6 时完全一致
+	testCases := map[string]string{
+		"2":  "2",
+		"11": "2",
+		"23": "4",
+		"27": "2",
+		"28": "2",
+	}
+	for k, v := range testCases {
+		if hash.Get(k) != v {
+			t.Errorf("Asking for %s, should have yielded %s", k, v)
+		}
+	}
+	if len(hash.keys) != 9 || len(hash.hashMap) != 9 {
+		t.Errorf("virtual nodes of 8 should be removed, keys %v", hash.keys)
+	}
+	if nodes := hash.Nodes(); !reflect.DeepEqual(nodes, []string{"2", "4", "6"}) {
+		t.Errorf("unexpected nodes %v", nodes)
+	}
+}
+
+func TestAddWeighted(t *testing.T) {
+	hash := New(10, nil)
+	hash.AddWeighted("big", 3)
+	hash.Add("small")
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[hash.Get(strconv.Itoa(i))]++
+	}
+	if counts["big"] <= counts["small"] {
+		t.Errorf("weighted node should take more keys, got %v", counts)
+	}
+
+	hash.AddWeighted("big", 1) // 重新设置权重
+	if len(hash.keys) != 20 {
+		t.Errorf("expect 20 virtual nodes after reweighting, got %d", len(hash.keys))
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := New(3, numberHash)
+	before.Add("6", "4", "2")
+	after := before.Clone()
+	after.Add("8")
+
+	// 节点 8 的虚拟节点 08/18/28 从节点 2 手中接管了 (6,8] (16,18] (26,28]
+	expect := []Range{
+		{Start: 6, End: 8, From: "2", To: "8"},
+		{Start: 16, End: 18, From: "2", To: "8"},
+		{Start: 26, End: 28, From: "2", To: "8"},
+	}
+	if moved := before.Diff(after); !reflect.DeepEqual(moved, expect) {
+		t.Errorf("unexpected moved ranges %v", moved)
+	}
+	if moved := after.Diff(before); len(moved) != 3 || moved[0].From != "8" || moved[0].To != "2" {
+		t.Errorf("unexpected moved ranges %v", moved)
+	}
+	if moved := before.Diff(before.Clone()); len(moved) != 0 {
+		t.Errorf("identical rings should not move, got %v", moved)
+	}
+}
+
+func TestGetN(t *testing.T) {
+	hash := New(3, numberHash)
+	hash.Add("6", "4", "2") // 虚拟节点 02/04/06/12/14/16/22/24/26
+
+	cases := []struct {
+		key    string
+		n      int
+		expect []string
+	}{
+		{"11", 1, []string{"2"}},
+		{"11", 2, []string{"2", "4"}},
+		{"15", 3, []string{"6", "2", "4"}},
+		{"27", 5, []string{"2", "4", "6"}}, // 只有 3 个节点
+		{"27", 0, nil},
+	}
+	for _, c := range cases {
+		if nodes := hash.GetN(c.key, c.n); !reflect.DeepEqual(nodes, c.expect) {
+			t.Errorf("GetN(%s, %d) = %v, expect %v", c.key, c.n, nodes, c.expect)
+		}
+	}
+	if nodes := hash.GetN("11", 1); nodes[0] != hash.Get("11") {
+		t.Errorf("the first node should match Get")
+	}
+}
+
+func TestBoundedLoad(t *testing.T) {
+	hash := New(3, numberHash)
+	hash.Add("6", "4", "2") // 虚拟节点 02/04/06/12/14/16/22/24/26
+	hash.SetLoadBound(0.25)
+
+	// 总负载为 0 时每个节点的容量为 ceil(1.25*1/3) = 1
+	if node := hash.GetLeast("11"); node != "2" {
+		t.Fatalf("expect 2, got %s", node)
+	}
+	hash.Inc("2")
+	// 总负载为 1 时容量仍为 1 节点 2 已满 交给顺时针的下一个节点 4
+	if node := hash.GetLeast("11"); node != "4" {
+		t.Fatalf("expect 4, got %s", node)
+	}
+	hash.Done("2")
+	if node := hash.GetLeast("11"); node != "2" {
+		t.Fatalf("expect 2 after its load is released, got %s", node)
+	}
+
+	// 所有请求都访问同一个热点 key 任何节点的负载都不超过上限
+	for i := 1; i <= 300; i++ {
+		hash.Inc(hash.GetLeast("11"))
+		for _, node := range hash.Nodes() {
+			if limit := int(math.Ceil(1.25 * float64(i) / 3)); hash.Load(node) > limit {
+				t.Fatalf("node %s has %d requests over the limit %d", node, hash.Load(node), limit)
+			}
+		}
+	}
+	hash.Remove("6")
+	hash.Done("6") // 已删除的节点
+	if hash.totalLoad != hash.Load("2")+hash.Load("4") {
+		t.Fatalf("total load %d should drop with the removed node", hash.totalLoad)
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	hash := New(1, numberHash)
+	if len(hash.Ownership()) != 0 {
+		t.Fatal("empty ring should own nothing")
+	}
+	hash.Add("1")
+	if owned := hash.Ownership(); owned["1"] != 1 {
+		t.Fatalf("a single node should own the whole ring, got %v", owned)
+	}
+	hash.Add("2")
+	hash.Add("1073741826") // 虚拟节点的哈希值为 1/2/1<<30+2
+	owned := hash.Ownership()
+	expect := map[string]float64{"1": 0.75 - 1.0/ringSize, "2": 1.0 / ringSize}
+	expect["1073741826"] = 1 - expect["1"] - expect["2"]
+	sum := 0.0
+	for node, f := range owned {
+		if math.Abs(f-expect[node]) > 1e-9 {
+			t.Errorf("node %s owns %v, expect %v", node, f, expect[node])
+		}
+		sum += f
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		t.Errorf("ownership should sum to 1, got %v", sum)
+	}
+
+	after := hash.Clone()
+	after.Remove("1073741826")
+	moved := hash.Diff(after)
+	if f := MovedFraction(moved); math.Abs(f-expect["1073741826"]) > 1e-9 {
+		t.Errorf("only the keys of the removed node should move, got %v", f)
+	}
+}
@@ -0,0 +1,106 @@
+package consistenthash
+
+import "sort"
+
+// Range 表示哈希环上的一段区间 (Start, End]
+// Start >= End 时表示区间跨过了 0 点 即 (Start, MaxUint32] 加上 [0, End]
+type Range struct {
+	Start uint32 `json:"start"`
+	End   uint32 `json:"end"`
+	From  string `json:"from"` // 变更前负责这段区间的节点 空字符串表示没有节点
+	To    string `json:"to"`   // 变更后负责这段区间的节点
+}
+
+// 整个哈希空间的大小 2^32
+const ringSize = 1 << 32
+
+// Size 返回区间包含的哈希值个数 Start == End 表示整个哈希空间
+func (r Range) Size() uint64 {
+	if r.Start == r.End {
+		return ringSize
+	}
+	return uint64(r.End - r.Start) // 跨过 0 点时 uint32 的减法自然回绕
+}
+
+// Fraction 返回区间占整个哈希空间的比例
+func (r Range) Fraction() float64 {
+	return float64(r.Size()) / ringSize
+}
+
+// Ownership 返回每个真实节点负责的哈希空间的比例 所有比例之和为 1
+// key 均匀分布时 比例即节点分到的 key 的比例 可用于评估负载是否均衡
+func (m *Map) Ownership() map[string]float64 {
+	owned := make(map[string]float64, len(m.weights))
+	for node := range m.weights {
+		owned[node] = 0
+	}
+	points := make([]int, 0, len(m.keys))
+	for i, p := range m.keys { // 哈希冲突时 m.keys 中可能有重复的点
+		if i == 0 || p != m.keys[i-1] {
+			points = append(points, p)
+		}
+	}
+	for i, end := range points {
+		start := points[(i+len(points)-1)%len(points)] // 第一个虚拟节点负责跨过 0 点的区间
+		r := Range{Start: uint32(start), End: uint32(end)}
+		owned[m.hashMap[end]] += r.Fraction()
+	}
+	return owned
+}
+
+// MovedFraction 返回 moved 中所有区间占整个哈希空间的比例 即节点变更后需要移动的 key 的比例
+func MovedFraction(moved []Range) float64 {
+	total := 0.0
+	for _, r := range moved {
+		total += r.Fraction()
+	}
+	return total
+}
+
+// 返回哈希值 hash 所属的真实节点 环为空时返回空字符串
+func (m *Map) owner(hash int) string {
+	if len(m.keys) == 0 {
+		return ""
+	}
+	return m.hashMap[m.keys[m.search(hash)]]
+}
+
+// Diff 比较 m（变更前）和 other（变更后）两个环 返回归属发生变化的区间
+// 相邻且变化相同的区间会被合并 结果按 End 升序排列
+func (m *Map) Diff(other *Map) []Range {
+	// 两个环上所有虚拟节点的位置把哈希空间切分成若干段 每一段内的归属在两个环上都不变
+	points := make([]int, 0, len(m.keys)+len(other.keys))
+	points = append(points, m.keys...)
+	points = append(points, other.keys...)
+	sort.Ints(points)
+	uniq := points[:0]
+	for i, p := range points {
+		if i == 0 || p != points[i-1] {
+			uniq = append(uniq, p)
+		}
+	}
+	points = uniq
+	if len(points) == 0 {
+		return nil
+	}
+
+	var moved []Range
+	for i, end := range points {
+		start := points[(i+len(points)-1)%len(points)] // 第一段的起点是最后一个点 即跨过 0 点的那一段
+		from, to := m.owner(end), other.owner(end)
+		if from == to {
+			continue
+		}
+		if n := len(moved); n > 0 && moved[n-1].End == uint32(start) && moved[n-1].From == from && moved[n-1].To == to {
+			moved[n-1].End = uint32(end) // 与上一段相邻 合并
+			continue
+		}
+		moved = append(moved, Range{Start: uint32(start), End: uint32(end), From: from, To: to})
+	}
+	// 最后一段和第一段在 0 点处相邻时也合并
+	if n := len(moved); n > 1 && moved[n-1].End == moved[0].Start && moved[n-1].From == moved[0].From && moved[n-1].To == moved[0].To {
+		moved[0].Start = moved[n-1].Start
+		moved = moved[:n-1]
+	}
+	return moved
+}
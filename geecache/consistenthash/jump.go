@@ -0,0 +1,104 @@
+package consistenthash
+
+import (
+	"hash/crc32"
+	"sort"
+)
+
+// Jump 实现跳跃一致性哈希 https://arxiv.org/abs/1406.2294
+// 算法把 key 映射到 [0, n) 中的一个桶 桶按节点加入的顺序编号
+// 在末尾添加节点时只有 1/n 的 key 移动到新节点 删除中间的节点时由最后一个节点补上它的位置
+// 所以除了被删除节点的 key 最后一个节点的 key 也会移动
+// 不同的服务器必须以相同的顺序 Add/Remove 节点 才能得到一致的结果
+// geecache.HTTPPool 每次节点变化后按地址排序重建 Jump 不依赖调用方的顺序
+type Jump struct {
+	hash  Hash
+	nodes []string // 下标即桶的编号
+}
+
+// NewJump 创建 Jump fn 为 nil 时使用 crc32.ChecksumIEEE
+func NewJump(fn Hash) *Jump {
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	return &Jump{hash: fn}
+}
+
+// Add 在末尾添加节点 已存在的节点忽略
+func (j *Jump) Add(nodes ...string) {
+	for _, node := range nodes {
+		if j.index(node) < 0 {
+			j.nodes = append(j.nodes, node)
+		}
+	}
+}
+
+// Remove 删除节点 最后一个节点移到被删除节点的位置
+func (j *Jump) Remove(node string) {
+	i := j.index(node)
+	if i < 0 {
+		return
+	}
+	last := len(j.nodes) - 1
+	j.nodes[i] = j.nodes[last]
+	j.nodes = j.nodes[:last]
+}
+
+func (j *Jump) index(node string) int {
+	for i, n := range j.nodes {
+		if n == node {
+			return i
+		}
+	}
+	return -1
+}
+
+// 跳跃一致性哈希 返回 key 所在的桶 [0, buckets)
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// Get 返回负责 key 的节点
+func (j *Jump) Get(key string) string {
+	if len(j.nodes) == 0 {
+		return ""
+	}
+	return j.nodes[jumpHash(mix(uint64(j.hash([]byte(key)))), len(j.nodes))]
+}
+
+// GetN 返回负责 key 的前 n 个节点
+// 选出一个节点后把它从候选中去掉 再用新的种子在剩下的节点中选择下一个
+func (j *Jump) GetN(key string, n int) []string {
+	if n <= 0 || len(j.nodes) == 0 {
+		return nil
+	}
+	if n > len(j.nodes) {
+		n = len(j.nodes)
+	}
+	candidates := make([]string, len(j.nodes))
+	copy(candidates, j.nodes)
+	keyHash := uint64(j.hash([]byte(key)))
+	nodes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		idx := jumpHash(mix(keyHash+uint64(i)), len(candidates))
+		nodes = append(nodes, candidates[idx])
+		last := len(candidates) - 1
+		candidates[idx] = candidates[last]
+		candidates = candidates[:last]
+	}
+	return nodes
+}
+
+// Nodes 返回所有节点 按名称排序
+func (j *Jump) Nodes() []string {
+	nodes := make([]string, len(j.nodes))
+	copy(nodes, j.nodes)
+	sort.Strings(nodes)
+	return nodes
+}
@@ -0,0 +1,141 @@
+package consistenthash
+
+import (
+	"hash/crc32"
+	"sort"
+)
+
+// 默认查找表的大小 必须是质数 并且远大于节点数
+const defaultMaglevSize = 65537
+
+// Maglev 实现 Maglev 一致性哈希 https://research.google/pubs/pub44824/
+// 每个节点根据自己的哈希值生成一个 [0, size) 的排列 各节点轮流按自己的排列填充查找表
+// 每个节点大约占 size/n 个槽位 查找时直接取 table[hash(key)%size]
+// 节点变更后重新生成查找表 大部分槽位的归属保持不变
+type Maglev struct {
+	hash  Hash
+	size  int
+	nodes []string // 按名称排序 填充查找表的顺序
+	table []int    // 槽位 -> nodes 中的下标
+}
+
+// NewMaglev 创建查找表大小为 size 的 Maglev size 不是质数时取下一个质数
+// size <= 0 时使用 defaultMaglevSize fn 为 nil 时使用 crc32.ChecksumIEEE
+func NewMaglev(size int, fn Hash) *Maglev {
+	if size <= 0 {
+		size = defaultMaglevSize
+	}
+	for !isPrime(size) {
+		size++
+	}
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	return &Maglev{hash: fn, size: size}
+}
+
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+	for i := 2; i*i <= n; i++ {
+		if n%i == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Add 添加节点并重新生成查找表 已存在的节点忽略
+func (m *Maglev) Add(nodes ...string) {
+	for _, node := range nodes {
+		if i := sort.SearchStrings(m.nodes, node); i < len(m.nodes) && m.nodes[i] == node {
+			continue
+		}
+		m.nodes = append(m.nodes, node)
+		sort.Strings(m.nodes)
+	}
+	m.populate()
+}
+
+// Remove 删除节点并重新生成查找表
+func (m *Maglev) Remove(node string) {
+	var ok bool
+	if m.nodes, ok = removeNode(m.nodes, node); ok {
+		m.populate()
+	}
+}
+
+// 按论文中的算法填充查找表
+// 节点 i 的排列为 (offset + j*skip) % size 由于 size 是质数 排列覆盖所有槽位
+func (m *Maglev) populate() {
+	if len(m.nodes) == 0 {
+		m.table = nil
+		return
+	}
+	offsets := make([]int, len(m.nodes))
+	skips := make([]int, len(m.nodes))
+	for i, node := range m.nodes {
+		h := mix(uint64(m.hash([]byte(node))))
+		offsets[i] = int(h % uint64(m.size))
+		skips[i] = int((h>>32)%uint64(m.size-1)) + 1
+	}
+	table := make([]int, m.size)
+	for i := range table {
+		table[i] = -1
+	}
+	next := make([]int, len(m.nodes)) // 每个节点在自己的排列中下一个要尝试的位置
+	for filled := 0; ; {
+		for i := range m.nodes {
+			slot := (offsets[i] + next[i]*skips[i]) % m.size
+			for table[slot] >= 0 {
+				next[i]++
+				slot = (offsets[i] + next[i]*skips[i]) % m.size
+			}
+			table[slot] = i
+			next[i]++
+			if filled++; filled == m.size {
+				m.table = table
+				return
+			}
+		}
+	}
+}
+
+// Get 返回负责 key 的节点
+func (m *Maglev) Get(key string) string {
+	if len(m.table) == 0 {
+		return ""
+	}
+	return m.nodes[m.table[m.slot(key)]]
+}
+
+func (m *Maglev) slot(key string) int {
+	return int(mix(uint64(m.hash([]byte(key)))) % uint64(m.size))
+}
+
+// GetN 从 key 所在的槽位开始依次向后 返回遇到的前 n 个不同的节点
+func (m *Maglev) GetN(key string, n int) []string {
+	if n <= 0 || len(m.table) == 0 {
+		return nil
+	}
+	if n > len(m.nodes) {
+		n = len(m.nodes)
+	}
+	nodes := make([]string, 0, n)
+	seen := make(map[int]bool, n)
+	for i, start := 0, m.slot(key); i < m.size && len(nodes) < n; i++ {
+		if idx := m.table[(start+i)%m.size]; !seen[idx] {
+			seen[idx] = true
+			nodes = append(nodes, m.nodes[idx])
+		}
+	}
+	return nodes
+}
+
+// Nodes 返回所有节点 按名称排序
+func (m *Maglev) Nodes() []string {
+	nodes := make([]string, len(m.nodes))
+	copy(nodes, m.nodes)
+	return nodes
+}
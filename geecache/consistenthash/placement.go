@@ -0,0 +1,44 @@
+package consistenthash
+
+/*
+Placement 决定每个 key 由哪个节点负责
+Map 是基于虚拟节点的哈希环 除此之外还有三种实现
+Rendezvous 最高随机权重（HRW）每个 key 选择与它组合后得分最高的节点 分布均匀 查找 O(n)
+Jump       跳跃一致性哈希 不占内存 分布最均匀 但只能在末尾增删节点时保证最少的移动
+Maglev     Google Maglev 使用的查找表 查找 O(1) 节点变更时移动的 key 略多于理论最小值
+负载分布和节点变更时移动的 key 的比较见 placement_test.go
+*/
+
+// Placement 是选择节点的算法 所有节点必须使用相同的算法和参数
+type Placement interface {
+	Add(nodes ...string)             // 添加节点
+	Remove(node string)              // 删除节点
+	Get(key string) string           // 返回负责 key 的节点 没有节点时返回空字符串
+	GetN(key string, n int) []string // 返回负责 key 的前 n 个不同的节点 第一个与 Get 的结果相同
+	Nodes() []string                 // 返回所有节点 按名称排序
+}
+
+var (
+	_ Placement = (*Map)(nil)
+	_ Placement = (*Rendezvous)(nil)
+	_ Placement = (*Jump)(nil)
+	_ Placement = (*Maglev)(nil)
+)
+
+// splitmix64 的混淆函数 把 32 位的哈希值扩展为分布均匀的 64 位
+func mix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// 在 nodes 中删除 node 保持其余节点的顺序 返回是否删除
+func removeNode(nodes []string, node string) ([]string, bool) {
+	for i, n := range nodes {
+		if n == node {
+			return append(nodes[:i], nodes[i+1:]...), true
+		}
+	}
+	return nodes, false
+}
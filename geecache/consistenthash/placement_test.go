@@ -0,0 +1,147 @@
+package consistenthash
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+// 比较各种 Placement 的负载分布和节点变更时移动的 key
+// go test -run Placement -v 查看具体数值
+var placements = []struct {
+	name string
+	new  func() Placement
+	// 允许的最大负载与平均负载之比
+	maxSpread float64
+}{
+	// crc32 对相似的虚拟节点名称分布不均匀 增加 replicas 也没有明显改善
+	{"ring-50", func() Placement { return New(50, nil) }, 1.4},
+	{"ring-500", func() Placement { return New(500, nil) }, 1.4},
+	{"rendezvous", func() Placement { return NewRendezvous(nil) }, 1.1},
+	{"jump", func() Placement { return NewJump(nil) }, 1.1},
+	{"maglev", func() Placement { return NewMaglev(0, nil) }, 1.1},
+}
+
+const placementKeys = 100000
+
+func placementNodes(n int) []string {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("http://10.0.0.%d:8001", i+1)
+	}
+	return nodes
+}
+
+// 记录每个 key 属于哪个节点
+func assign(p Placement) []string {
+	owners := make([]string, placementKeys)
+	for i := range owners {
+		owners[i] = p.Get("key" + strconv.Itoa(i))
+	}
+	return owners
+}
+
+func TestPlacementSpread(t *testing.T) {
+	nodes := placementNodes(10)
+	for _, c := range placements {
+		p := c.new()
+		p.Add(nodes...)
+		load := make(map[string]int)
+		for _, owner := range assign(p) {
+			load[owner]++
+		}
+		max, min := 0, placementKeys
+		for _, node := range nodes {
+			if load[node] > max {
+				max = load[node]
+			}
+			if load[node] < min {
+				min = load[node]
+			}
+		}
+		mean := float64(placementKeys) / float64(len(nodes))
+		t.Logf("%-10s max/mean %.3f min/mean %.3f", c.name, float64(max)/mean, float64(min)/mean)
+		if float64(max)/mean > c.maxSpread {
+			t.Errorf("%s: max load %d is %.2f times the mean", c.name, max, float64(max)/mean)
+		}
+	}
+}
+
+// 比较 before 和 after 返回发生移动的 key 的比例
+// 移动的 key 不应该在两个都没有变化的节点之间移动
+func moved(t *testing.T, name string, before, after []string, changed string) float64 {
+	n := 0
+	for i := range before {
+		if before[i] == after[i] {
+			continue
+		}
+		n++
+		if before[i] != changed && after[i] != changed && name != "jump" && name != "maglev" {
+			t.Fatalf("%s: key%d moved from %s to %s", name, i, before[i], after[i])
+		}
+	}
+	return float64(n) / placementKeys
+}
+
+func TestPlacementMovement(t *testing.T) {
+	nodes := placementNodes(11)
+	for _, c := range placements {
+		p := c.new()
+		p.Add(nodes[:10]...)
+		before := assign(p)
+
+		p.Add(nodes[10])
+		added := moved(t, c.name, before, assign(p), nodes[10])
+		p.Remove(nodes[10])
+		if back := moved(t, c.name, before, assign(p), nodes[10]); back != 0 {
+			t.Errorf("%s: removing the new node should restore all keys, %.3f still moved", c.name, back)
+		}
+		p.Remove(nodes[3])
+		removed := moved(t, c.name, before, assign(p), nodes[3])
+
+		t.Logf("%-10s add 1/11 moved %.3f remove 1/10 moved %.3f", c.name, added, removed)
+		// 理论最小值分别为 1/11 和 1/10 jump 删除中间节点时最后一个节点也会移动
+		if added > 1.5/11 || removed > 2.5/10 {
+			t.Errorf("%s: too many keys moved, add %.3f remove %.3f", c.name, added, removed)
+		}
+	}
+}
+
+func TestPlacementGetN(t *testing.T) {
+	for _, c := range placements {
+		p := c.new()
+		if p.Get("key") != "" || len(p.GetN("key", 2)) != 0 {
+			t.Fatalf("%s: empty placement should return no node", c.name)
+		}
+		p.Add(placementNodes(5)...)
+		p.Add(placementNodes(1)...) // 重复添加
+		if len(p.Nodes()) != 5 {
+			t.Fatalf("%s: expect 5 nodes, got %v", c.name, p.Nodes())
+		}
+		for i := 0; i < 100; i++ {
+			key := "key" + strconv.Itoa(i)
+			nodes := p.GetN(key, 3)
+			if len(nodes) != 3 || nodes[0] != p.Get(key) {
+				t.Fatalf("%s: GetN(%s, 3) = %v, Get = %s", c.name, key, nodes, p.Get(key))
+			}
+			if nodes[0] == nodes[1] || nodes[0] == nodes[2] || nodes[1] == nodes[2] {
+				t.Fatalf("%s: GetN returns duplicated nodes %v", c.name, nodes)
+			}
+		}
+		if len(p.GetN("key", 10)) != 5 {
+			t.Fatalf("%s: GetN should return at most all nodes", c.name)
+		}
+	}
+}
+
+func BenchmarkPlacementGet(b *testing.B) {
+	for _, c := range placements {
+		p := c.new()
+		p.Add(placementNodes(10)...)
+		b.Run(c.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p.Get("key" + strconv.Itoa(i&1023))
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package consistenthash
+
+import (
+	"hash/crc32"
+	"sort"
+)
+
+// Rendezvous 实现最高随机权重哈希（HRW）
+// 每个节点与 key 组合计算一个得分 得分最高的节点负责 key
+// 删除节点时只有它负责的 key 会移动 添加节点时只有新节点得分最高的 key 会移动
+type Rendezvous struct {
+	hash   Hash
+	nodes  []string // 按名称排序
+	hashes map[string]uint64
+}
+
+// NewRendezvous 创建 Rendezvous fn 为 nil 时使用 crc32.ChecksumIEEE
+func NewRendezvous(fn Hash) *Rendezvous {
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	return &Rendezvous{hash: fn, hashes: make(map[string]uint64)}
+}
+
+// Add 添加节点 已存在的节点忽略
+func (r *Rendezvous) Add(nodes ...string) {
+	for _, node := range nodes {
+		if _, ok := r.hashes[node]; ok {
+			continue
+		}
+		r.hashes[node] = mix(uint64(r.hash([]byte(node))))
+		r.nodes = append(r.nodes, node)
+	}
+	sort.Strings(r.nodes)
+}
+
+// Remove 删除节点
+func (r *Rendezvous) Remove(node string) {
+	if _, ok := r.hashes[node]; !ok {
+		return
+	}
+	delete(r.hashes, node)
+	r.nodes, _ = removeNode(r.nodes, node)
+}
+
+// 节点 node 对 key 的得分
+func (r *Rendezvous) score(node string, keyHash uint64) uint64 {
+	return mix(r.hashes[node] ^ keyHash)
+}
+
+// Get 返回得分最高的节点 得分相同时选名称较小的
+func (r *Rendezvous) Get(key string) string {
+	keyHash := uint64(r.hash([]byte(key)))
+	best, bestScore := "", uint64(0)
+	for _, node := range r.nodes {
+		if s := r.score(node, keyHash); best == "" || s > bestScore {
+			best, bestScore = node, s
+		}
+	}
+	return best
+}
+
+// GetN 返回得分最高的 n 个节点 按得分从高到低排列
+func (r *Rendezvous) GetN(key string, n int) []string {
+	if n <= 0 || len(r.nodes) == 0 {
+		return nil
+	}
+	keyHash := uint64(r.hash([]byte(key)))
+	nodes := make([]string, len(r.nodes))
+	copy(nodes, r.nodes)
+	scores := make(map[string]uint64, len(nodes))
+	for _, node := range nodes {
+		scores[node] = r.score(node, keyHash)
+	}
+	sort.SliceStable(nodes, func(i, j int) bool { return scores[nodes[i]] > scores[nodes[j]] })
+	if n > len(nodes) {
+		n = len(nodes)
+	}
+	return nodes[:n]
+}
+
+// Nodes 返回所有节点 按名称排序
+func (r *Rendezvous) Nodes() []string {
+	nodes := make([]string, len(r.nodes))
+	copy(nodes, r.nodes)
+	return nodes
+}
@@ -0,0 +1,98 @@
+package geecache
+
+import (
+	pb "Cache/geecache/geecachepb"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// 此部分负责节点间的错误模型
+// 远程节点把错误编码为 pb.Response 中的 code 和 message 请求方据此还原出 *PeerError
+
+// PeerError 表示远程节点明确返回的错误
+type PeerError struct {
+	Peer    string  // 远程节点的地址
+	Code    pb.Code // 错误类型
+	Message string  // 远程节点的错误描述
+}
+
+func (e *PeerError) Error() string {
+	if e.Peer == "" { // 批量请求中单个 key 的错误 不知道来自哪个节点
+		return fmt.Sprintf("peer: %s: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("peer %s: %s: %s", e.Peer, e.Code, e.Message)
+}
+
+// Unwrap 使 errors.Is(err, ErrNotFound) 对远程返回的 NOT_FOUND 同样成立
+func (e *PeerError) Unwrap() error {
+	if e.Code == pb.Code_NOT_FOUND {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// 把本地 Get 的错误归类为 pb.Code 远程节点返回的错误沿用原来的 Code
+func codeOf(err error) pb.Code {
+	var perr *PeerError
+	switch {
+	case err == nil:
+		return pb.Code_OK
+	case errors.As(err, &perr):
+		return perr.Code
+	case errors.Is(err, ErrNotFound):
+		return pb.Code_NOT_FOUND
+	case errors.Is(err, context.DeadlineExceeded):
+		return pb.Code_DEADLINE_EXCEEDED
+	case errors.Is(err, context.Canceled):
+		return pb.Code_UNAVAILABLE
+	}
+	return pb.Code_INTERNAL
+}
+
+// pb.Code 与 HTTP 状态码之间的映射
+func httpStatus(code pb.Code) int {
+	switch code {
+	case pb.Code_OK:
+		return http.StatusOK
+	case pb.Code_NOT_FOUND:
+		return http.StatusNotFound
+	case pb.Code_UNAVAILABLE:
+		return http.StatusServiceUnavailable
+	case pb.Code_DEADLINE_EXCEEDED:
+		return http.StatusGatewayTimeout
+	case pb.Code_INVALID_ARGUMENT:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// 响应体不是 pb.Response 时（如经过了代理） 只能根据状态码推断
+// 404 和 500 可能来自中间的代理 不能说明远程节点已经加载过 key 都当作 UNAVAILABLE 允许本地加载
+func codeFromHTTPStatus(status int) pb.Code {
+	switch status {
+	case http.StatusOK:
+		return pb.Code_OK
+	case http.StatusBadRequest:
+		return pb.Code_INVALID_ARGUMENT
+	case http.StatusGatewayTimeout:
+		return pb.Code_DEADLINE_EXCEEDED
+	}
+	return pb.Code_UNAVAILABLE
+}
+
+// 从远程节点获取失败后 是否应该改为调用本地的回调函数
+// 远程节点确认不存在 或已经调用过回调函数但失败了 本地再加载一次只会给数据源增加压力
+// 网络错误 超时 远程节点暂时不可用时 本地加载可以保证可用性
+func shouldFallback(err error) bool {
+	var perr *PeerError
+	if !errors.As(err, &perr) {
+		return true // 网络错误等 没有拿到远程节点的响应
+	}
+	switch perr.Code {
+	case pb.Code_NOT_FOUND, pb.Code_INTERNAL:
+		return false
+	}
+	return true
+}
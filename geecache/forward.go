@@ -0,0 +1,95 @@
+package geecache
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+转发保护
+节点列表不一致时 A 认为 key 属于 B B 又认为 key 属于 A 请求会在两者之间来回转发直到超时
+每个发给远程节点的请求都带有 hops（HTTP 中为 X-Geecache-Hops 头）接收方看到 hops>0 时只从本地加载
+请求还带有发送方节点列表的版本 与接收方的版本不同时记录日志 见 PoolStats.RingMismatches
+*/
+
+const (
+	hopsHeader        = "X-Geecache-Hops"
+	ringVersionHeader = "X-Geecache-Ring-Version"
+)
+
+type forwardedKey struct{}
+
+// 标记 ctx 所属的请求已经被其他节点转发过
+func withForwarded(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forwardedKey{}, true)
+}
+
+// 请求已经被转发过时 Group 只从本地加载
+func isForwarded(ctx context.Context) bool {
+	forwarded, _ := ctx.Value(forwardedKey{}).(bool)
+	return forwarded
+}
+
+// membership 记录本节点的节点列表版本 并检查远程节点发来的版本
+type membership struct {
+	mu         sync.Mutex
+	version    string
+	mismatched map[string]bool // 已经记录过日志的远程版本 避免每个请求都打印
+	mismatches AtomicInt       // 收到的版本不一致的请求数
+}
+
+// 节点列表的版本 与顺序无关 所有节点使用相同的列表时版本相同
+func ringVersion(peers []string) string {
+	sorted := make([]string, len(peers))
+	copy(sorted, peers)
+	sort.Strings(sorted)
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(sorted, "\n")))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// 节点列表变化后更新版本
+func (m *membership) set(peers []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.version = ringVersion(peers)
+	m.mismatched = nil
+}
+
+func (m *membership) get() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.version
+}
+
+// 比较 from 发来的版本 remote 与本节点的版本 不同时计数 每个新的版本记录一次日志
+// 任一方还没有设置节点列表时不比较
+func (m *membership) check(remote, from string, logf func(format string, v ...interface{})) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if remote == "" || m.version == "" || remote == m.version {
+		return
+	}
+	m.mismatches.Add(1)
+	if m.mismatched[remote] {
+		return
+	}
+	if m.mismatched == nil {
+		m.mismatched = make(map[string]bool)
+	}
+	m.mismatched[remote] = true
+	logf("ring version mismatch: %s has %s, self has %s, check the peer lists", from, remote, m.version)
+}
+
+// singleflight 使用的 key 被转发的请求只从本地加载 使用单独的 key
+// 否则可能合并到一个正在转发给对方的加载中 两个节点互相等待直到超时
+func flightKey(ctx context.Context, key string) string {
+	if isForwarded(ctx) {
+		return "\x00forwarded/" + key
+	}
+	return key
+}
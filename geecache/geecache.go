@@ -3,9 +3,15 @@ package geecache
 import (
 	pb "Cache/geecache/geecachepb"
 	"Cache/geecache/singleflight"
+	"Cache/lru"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // 此部分负责与外部交互 控制缓存存储和获取的主流程
@@ -29,17 +35,44 @@ import (
 比如可以创建三个Group
 缓存学生成绩的命名为scores，缓存学生信息的命名为 info， 缓存学生课程的命名为 courses
 getter 为 缓存未命中是获取源数据的回调 callback
-mainCache  就是一开始实现的 并发缓存
+mainCache  就是一开始实现的 并发缓存 可以分片 见 WithShards
 */
 type Group struct {
 	name      string
 	getter    Getter
-	mainCache cache
+	mainCache store
 	peers     PeerPicker // 增加分布式
 	// 用singlefight.Group 确保 每个key 只被fetch 一次
 	loader *singleflight.Group
+	ttl    time.Duration // 缓存数据的默认存活时间 0 表示永不过期
+	// hotCache 缓存由远程节点负责、但在本地被频繁访问的 key 避免每次都走网络
+	// 从 cacheBytes 中划出 hotRatio 的比例 每次远程获取后以 hotProbability 的概率放入
+	hotCache       cache
+	hotRatio       float64
+	hotProbability float64
+	stats          groupStats    // 统计信息 通过 Stats() 获取快照
+	negativeTTL    time.Duration // "不存在"结果的缓存时间 0 表示不缓存
+	refreshAhead   time.Duration // 剩余存活时间小于它时 在后台提前刷新
+	refreshing     sync.Map      // 正在后台刷新的 key 避免每次访问都启动一个协程
+	config         cacheConfig   // mainCache 和 hotCache 的配置 由 GroupOption 设置
+	shards         int           // mainCache 的分片数 <=1 表示不分片
+	// 远程请求的重试和熔断 见 WithRetry WithCircuitBreaker
+	retries          int
+	retryBackoff     time.Duration
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	breakers         sync.Map      // PeerGetter -> *breaker
+	hedgeDelay       time.Duration // 主节点超过这个时间没有返回就发送对冲请求 见 WithHedgeDelay
+	// 多副本 见 replica.go
+	pushing chan struct{} // 正在进行的推送 容量为 maxPushes
+	removed tombstones    // 最近被删除的 key 用于丢弃删除之前发出的推送
 }
 
+// ErrNotFound 表示数据源中不存在该 key
+// 回调函数返回的错误包裹了 ErrNotFound 时（errors.Is 为真） 该结果可以被缓存 WithNegativeTTL
+// 远程节点返回"不存在"时 Get 也返回包裹了 ErrNotFound 的错误 而不是普通的失败
+var ErrNotFound = errors.New("geecache: key not found")
+
 // 定义接口 Getter  和 回调函数 Get
 type Getter interface {
 	Get(key string) ([]byte, error)
@@ -59,6 +92,154 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// TTLGetter 是可选接口 回调函数除了返回源数据 还可以为每个key单独指定存活时间
+// 返回的 ttl <= 0 时使用 Group 的默认 ttl
+type TTLGetter interface {
+	GetWithTTL(key string) ([]byte, time.Duration, error)
+}
+
+// TTLGetterFunc 同时实现 Getter 和 TTLGetter 接口
+type TTLGetterFunc func(key string) ([]byte, time.Duration, error)
+
+// Get 进行拉取 忽略返回的 ttl
+func (f TTLGetterFunc) Get(key string) ([]byte, error) {
+	b, _, err := f(key)
+	return b, err
+}
+
+// GetWithTTL 进行拉取 并返回该 key 的存活时间
+func (f TTLGetterFunc) GetWithTTL(key string) ([]byte, time.Duration, error) {
+	return f(key)
+}
+
+// GetterContext 是可选接口 回调函数可以感知调用方的 ctx
+// 调用方取消或超时后 慢查询的数据源可以尽早返回
+type GetterContext interface {
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// GetterContextFunc 同时实现 Getter 和 GetterContext 接口
+type GetterContextFunc func(ctx context.Context, key string) ([]byte, error)
+
+// Get 使用 context.Background() 进行拉取
+func (f GetterContextFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
+// GetContext 进行拉取
+func (f GetterContextFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// TTLGetterContext 是 TTLGetter 的 ctx 版本 优先级最高
+type TTLGetterContext interface {
+	GetWithTTLContext(ctx context.Context, key string) ([]byte, time.Duration, error)
+}
+
+// GroupOption 用于在 NewGroup 时配置 Group 的可选项
+type GroupOption func(*Group)
+
+// WithTTL 设置缓存数据的默认存活时间
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ttl = ttl
+	}
+}
+
+// WithEvicted 设置缓存记录被移除时的回调 reason 说明了移除的原因（容量淘汰 过期 Remove Set 覆盖 Purge）
+// 回调在释放缓存的锁之后调用 可以在回调中调用 group 的方法
+func WithEvicted(fn func(key string, value ByteView, reason lru.EvictReason)) GroupOption {
+	return func(g *Group) {
+		g.config.onEvicted = fn
+	}
+}
+
+// WithHotCache 开启热点缓存 ratio 为从 cacheBytes 中划给热点缓存的比例（0~1）
+// probability 为远程获取的值被放入热点缓存的概率 只有被反复访问的 key 才大概率留在本地
+// ratio 不在 (0, 1) 范围内时不开启热点缓存 否则热点缓存没有内存上限
+func WithHotCache(ratio float64, probability float64) GroupOption {
+	return func(g *Group) {
+		if ratio <= 0 || ratio >= 1 {
+			ratio = 0
+		}
+		g.hotRatio = ratio
+		g.hotProbability = probability
+	}
+}
+
+// WithNegativeTTL 缓存回调函数返回的 ErrNotFound ttl 时间内再次查询同一个 key 不会访问数据源
+// 不存在的结果与正常数据共用 mainCache 的容量 Remove Set 同样会清除它
+func WithNegativeTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.negativeTTL = ttl
+	}
+}
+
+// WithPolicy 选择淘汰策略 如 lru.LFU lru.ARC lru.TwoQueue lru.TinyLFU 默认为 lru.LRU
+// 热点缓存使用同样的策略
+func WithPolicy(newPolicy lru.Factory) GroupOption {
+	return func(g *Group) {
+		g.config.newPolicy = newPolicy
+	}
+}
+
+// WithSweepInterval 设置后台清理过期数据的间隔 <=0 表示不启动后台清理 只在 Get 时惰性过期
+func WithSweepInterval(interval time.Duration) GroupOption {
+	return func(g *Group) {
+		g.config.sweepInterval = interval
+	}
+}
+
+// WithStaleWhileRevalidate 数据过期后继续保留 d 时间 期间 Get 立即返回旧值
+// 同时在后台刷新 刷新失败时继续返回旧值 直到超过 d
+func WithStaleWhileRevalidate(d time.Duration) GroupOption {
+	return func(g *Group) {
+		g.config.staleFor = d
+	}
+}
+
+// WithShards 把 mainCache 分成 n 个分片 每个分片有自己的锁和约 cacheBytes/n 的容量
+// 多核高并发时减少锁竞争 淘汰只在分片内部进行
+func WithShards(n int) GroupOption {
+	return func(g *Group) {
+		g.shards = n
+	}
+}
+
+// WithRetry 从远程节点获取时遇到暂时性的错误（网络错误 超时 节点不可用）最多重试 retries 次
+// 第 i 次重试前等待 backoff*2^i 的一半到全部之间的随机时间 避免所有请求同时重试
+func WithRetry(retries int, backoff time.Duration) GroupOption {
+	return func(g *Group) {
+		g.retries = retries
+		g.retryBackoff = backoff
+	}
+}
+
+// WithCircuitBreaker 为每个远程节点开启熔断 连续失败 threshold 次后打开
+// 打开期间直接本地加载 不再等待该节点超时 cooldown 之后放行一个请求试探
+func WithCircuitBreaker(threshold int, cooldown time.Duration) GroupOption {
+	return func(g *Group) {
+		g.breakerThreshold = threshold
+		g.breakerCooldown = cooldown
+	}
+}
+
+// WithHedgeDelay 从远程节点获取超过 d 还没有返回时 再向哈希环上的第二个节点发送一次请求
+// 第二个节点是自己或 PeerPicker 没有实现 PeersPicker 时改为本地加载 哪个先返回用哪个
+func WithHedgeDelay(d time.Duration) GroupOption {
+	return func(g *Group) {
+		g.hedgeDelay = d
+	}
+}
+
+// WithRefreshAhead 访问到剩余存活时间小于 d 的数据时 在后台提前刷新
+// 热门 key 在过期前就被更新 Get 不会因为过期而等待加载
+func WithRefreshAhead(d time.Duration) GroupOption {
+	return func(g *Group) {
+		g.refreshAhead = d
+	}
+}
+
 // 全局变量
 var (
 	mu     sync.RWMutex
@@ -66,18 +247,39 @@ var (
 )
 
 // 实例化 Group 并将 group 存储在全局变量 groups 中
-// 参数为 name group 名字 cacheBytes 缓存空间大小 getter 回调函数
-func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+// 参数为 name group 名字 cacheBytes 缓存空间大小 getter 回调函数 opts 可选配置
+func NewGroup(name string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
 	if getter == nil {
 		panic("nil Getter")
 	}
 	mu.Lock()
 	defer mu.Unlock()
 	g := &Group{
-		name:      name,
-		getter:    getter,
-		mainCache: cache{cacheBytes: cacheBytes},
-		loader:    &singleflight.Group{},
+		name:    name,
+		getter:  getter,
+		loader:  &singleflight.Group{},
+		config:  cacheConfig{sweepInterval: defaultSweepInterval},
+		pushing: make(chan struct{}, maxPushes),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.hotRatio > 0 {
+		hotBytes := int64(float64(cacheBytes) * g.hotRatio)
+		if hotBytes == 0 && cacheBytes > 1 { // 0 表示不限制 至少划出 1 字节
+			hotBytes = 1
+		}
+		cacheBytes -= hotBytes
+		g.hotCache = cache{cacheConfig: g.config, cacheBytes: hotBytes}
+		g.hotCache.staleFor = 0 // 热点缓存的数据属于远程节点 过期后不保留
+	}
+	if g.shards > 1 {
+		g.mainCache = newShardedCache(g.shards, cacheBytes, g.config)
+	} else {
+		g.mainCache = &cache{cacheConfig: g.config, cacheBytes: cacheBytes}
+	}
+	if old, ok := groups[name]; ok { // 同名的旧 group 不再能通过 GetGroup 取到 停止它的后台清理协程
+		old.Close()
 	}
 	groups[name] = g
 
@@ -92,68 +294,297 @@ func GetGroup(name string) *Group {
 	return g
 }
 
+// 返回当前所有 group 的拷贝 供统计接口遍历
+func allGroups() map[string]*Group {
+	mu.RLock()
+	defer mu.RUnlock()
+	all := make(map[string]*Group, len(groups))
+	for name, g := range groups {
+		all[name] = g
+	}
+	return all
+}
+
 // 接下来是 GeeCache 最为核心的方法Get
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext 与 Get 相同 ctx 会一直传递到远程节点和回调函数 取消或超时后尽早返回
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
+	g.stats.Gets.Add(1)
 	if key == "" { // 判断key是否合法
 		return ByteView{}, fmt.Errorf("key is required")
 	}
+	if v, ok, err := g.lookupCache(key); ok {
+		return v, err
+	}
+	return g.load(ctx, key) // 没找到 调用load 方法
+}
+
+// 依次在本地缓存和热点缓存中查找 key 并统计命中情况
+// 命中缓存的"不存在"时 ok 为 true 且返回 ErrNotFound
+func (g *Group) lookupCache(key string) (value ByteView, ok bool, err error) {
 	if v, ok := g.mainCache.get(key); ok { // 在本地缓存中查找
-		fmt.Println("查找本地缓存")
-		log.Println("[GeeCache] hit")
-		return v, nil
+		g.stats.Hits.Add(1)
+		if v.notFound { // 缓存的是"不存在" 过期后直接重新加载 不在后台刷新
+			return ByteView{}, true, notFoundError(key)
+		}
+		g.maybeRefresh(key, v)
+		return v, true, nil
 	}
-	return g.load(key) // 没找到 调用load 方法
+	if v, ok := g.hotCache.get(key); ok { // 在热点缓存中查找 命中则不必再访问远程节点
+		g.stats.Hits.Add(1)
+		return v, true, nil
+	}
+	g.stats.Misses.Add(1)
+	return ByteView{}, false, nil
+}
+
+// 后台刷新最多等待的时间 超时后由之后的访问再次触发刷新
+const refreshTimeout = 10 * time.Second
+
+// 过期（stale-while-revalidate）或快要过期（refresh-ahead）的数据在后台刷新
+func (g *Group) maybeRefresh(key string, v ByteView) {
+	if v.Expire().IsZero() {
+		return
+	}
+	left := time.Until(v.Expire())
+	if left <= 0 {
+		g.stats.StaleHits.Add(1)
+	} else if left >= g.refreshAhead {
+		return
+	}
+	if _, loading := g.refreshing.LoadOrStore(key, struct{}{}); loading {
+		return
+	}
+	g.stats.Refreshes.Add(1)
+	go func() {
+		defer g.refreshing.Delete(key)
+		// 通过 singleflight 与同时发生的 Get 共享同一次加载
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+		if _, err := g.load(ctx, key); err != nil {
+			log.Println("[GeeCache] Failed to refresh", key, err)
+		}
+	}()
 }
 
 // day 06 修改增加 Do 将原来的load逻辑用Do包裹起来，这样确保了并发场景下针对相同的key，load过程只会调用一次
-func (g *Group) load(key string) (value ByteView, err error) {
+func (g *Group) load(ctx context.Context, key string) (value ByteView, err error) {
 	// 每个key 只被fetch 一次（无论本地还是远程）
 	// 不管并发调用者的数量
-	viewi, err := g.loader.Do(key, func() (i interface{}, e error) {
-		// 更新分布式场景
-		if g.peers != nil {
-			if peer, ok := g.peers.PickPeer(key); ok {
-				if value, err = g.getFromPeer(peer, key); err == nil { // 注意此处 判断为 err == nil  没出错将数据返回
+	var called int32 // fn 没有被调用 说明这次请求被 singleflight 合并了 fn 在其他协程中执行 使用原子操作
+	viewi, err := g.loader.DoContext(ctx, flightKey(ctx, key), func(ctx context.Context) (i interface{}, e error) {
+		atomic.StoreInt32(&called, 1)
+		replicas := g.replicas(key) // 没有开启多副本时为 nil
+		// 更新分布式场景 已经被其他节点转发过的请求只从本地加载 避免节点列表不一致时来回转发
+		if g.peers != nil && !isForwarded(ctx) {
+			if replicas != nil {
+				return g.getFromReplicas(ctx, key, replicas, 0)
+			}
+			if peer, release, ok := g.pickPeer(key); ok {
+				value, local, err := g.getFromPeerHedged(ctx, peer, key)
+				release()
+				if local { // 对冲请求已经从本地加载 统计由 getLoacally 完成
+					return value, err
+				}
+				if err == nil { // 注意此处 判断为 err == nil  没出错将数据返回
+					g.stats.PeerLoads.Add(1)
 					return value, nil
 				}
+				// 远程节点明确告知不存在 不必再从本地加载
+				if errors.Is(err, ErrNotFound) {
+					g.stats.PeerLoads.Add(1)
+					return nil, err
+				}
+				g.stats.PeerErrors.Add(1)
 				log.Println("[GeeCache] Failed to get from peer", err) // 出错了 打印错误  之后从本地节点取数据
+				// 远程节点已经调用过回调函数并失败了 本地再加载一次只会加重数据源的负担
+				if !shouldFallback(err) {
+					return nil, err
+				}
+				// 调用方已经放弃 不必再从本地加载
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
 			}
 		}
-		return g.getLoacally(key) // 从本地节点获取
+		return g.getLocallyAndPush(ctx, key, replicas) // 从本地节点获取
 		// 分布式场景下回调用 getFromPeer 从其他节点获取
 	})
+	if atomic.LoadInt32(&called) == 0 {
+		g.stats.Dedups.Add(1)
+	}
 	if err == nil {
 		return viewi.(ByteView), nil
 	}
 	return
 }
 
-func (g *Group) getLoacally(key string) (ByteView, error) {
+// 选择负责 key 的远程节点 请求结束后调用返回的 release
+func (g *Group) pickPeer(key string) (PeerGetter, func(), bool) {
+	if picker, ok := g.peers.(LoadPicker); ok {
+		return picker.PickPeerLoad(key)
+	}
+	peer, ok := g.peers.PickPeer(key)
+	return peer, func() {}, ok
+}
+
+func (g *Group) getLoacally(ctx context.Context, key string) (ByteView, error) {
 	// fmt.Println("从本地节点取数据")
-	bytes, err := g.getter.Get(key) // 调用用户回调函数 获取源数据 回调函数自己定义的
+	var (
+		bytes []byte
+		ttl   time.Duration
+		err   error
+	)
+	// 调用用户回调函数 获取源数据 回调函数自己定义的
+	// 按 TTLGetterContext GetterContext TTLGetter Getter 的顺序选择回调实现的接口
+	switch getter := g.getter.(type) {
+	case TTLGetterContext:
+		bytes, ttl, err = getter.GetWithTTLContext(ctx, key)
+	case GetterContext:
+		bytes, err = getter.GetContext(ctx, key)
+	case TTLGetter:
+		bytes, ttl, err = getter.GetWithTTL(key)
+	default:
+		bytes, err = getter.Get(key)
+	}
+	return g.loaded(key, bytes, ttl, err)
+}
+
+// 处理从数据源加载 key 的结果 统计并放入缓存 Get 和 GetMulti 共用
+func (g *Group) loaded(key string, bytes []byte, ttl time.Duration, err error) (ByteView, error) {
 	if err != nil {
+		g.stats.LocalLoadErrs.Add(1)
 		fmt.Println(err)
+		if g.negativeTTL > 0 && errors.Is(err, ErrNotFound) { // 缓存"不存在" 避免反复查询数据源
+			g.populateCache(key, g.notFoundView())
+		}
 		return ByteView{}, err
 	}
-	value := ByteView{b: cloneBytes(bytes)} // 返回拷贝
-	g.populateCache(key, value)             // 并将源数据添加到缓存中
+	g.stats.LocalLoads.Add(1)
+	value := ByteView{b: cloneBytes(bytes), e: g.expireAt(ttl)} // 返回拷贝
+	g.populateCache(key, value)                                 // 并将源数据添加到缓存中
 	return value, nil
 }
 
+// 返回包裹了 ErrNotFound 的错误 带上 key 方便排查
+func notFoundError(key string) error {
+	return fmt.Errorf("%s: %w", key, ErrNotFound)
+}
+
+// 缓存"不存在"时使用的值 在 negativeTTL 之后过期
+func (g *Group) notFoundView() ByteView {
+	return ByteView{e: time.Now().Add(g.negativeTTL), notFound: true}
+}
+
+// 根据 ttl 计算过期时间 ttl <= 0 时使用默认 ttl 两者都没有则永不过期
+func (g *Group) expireAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		ttl = g.ttl
+	}
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// Set 直接写入或覆盖 key 对应的缓存值 使用 Group 的默认 ttl
+// 写数据库之后调用 可以让缓存与数据库保持一致
+func (g *Group) Set(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	g.populateCache(key, ByteView{b: cloneBytes(value), e: g.expireAt(0)})
+	return nil
+}
+
+// Remove 从本地缓存中删除 key 下次 Get 时会重新加载
+// 注册的 PeerPicker 实现了 PeerInvalidator 时 还会通知所有远程节点一起删除
+func (g *Group) Remove(key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	g.removeLocally(key)
+	if inv, ok := g.peers.(PeerInvalidator); ok {
+		return inv.Invalidate(g.name, key)
+	}
+	return nil
+}
+
+// 只删除本地缓存（包括热点缓存） 远程节点收到删除广播时调用
+func (g *Group) removeLocally(key string) {
+	g.removed.add(key)
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+}
+
+// Purge 清空本地缓存 包括热点缓存
+func (g *Group) Purge() {
+	g.mainCache.purge()
+	g.hotCache.purge()
+}
+
+// Close 停止后台清理过期数据的协程 不再使用 group 时调用 避免协程泄漏
+// 之后 group 仍然可以使用 过期数据只在 Get 时惰性清理
+func (g *Group) Close() {
+	g.mainCache.close()
+	g.hotCache.close()
+}
+
 // 将数据添加到缓存中
 func (g *Group) populateCache(key string, value ByteView) {
 	g.mainCache.add(key, value)
 }
 
+// Name 返回 group 的名字
+func (g *Group) Name() string {
+	return g.name
+}
+
+// Stats 返回 group 统计信息的快照
+func (g *Group) Stats() Stats {
+	s := Stats{
+		Gets:           g.stats.Gets.Get(),
+		Hits:           g.stats.Hits.Get(),
+		Misses:         g.stats.Misses.Get(),
+		PeerLoads:      g.stats.PeerLoads.Get(),
+		PeerErrors:     g.stats.PeerErrors.Get(),
+		LocalLoads:     g.stats.LocalLoads.Get(),
+		LocalLoadErrs:  g.stats.LocalLoadErrs.Get(),
+		Dedups:         g.stats.Dedups.Get(),
+		StaleHits:      g.stats.StaleHits.Get(),
+		Refreshes:      g.stats.Refreshes.Get(),
+		PeerRetries:    g.stats.PeerRetries.Get(),
+		BreakerRejects: g.stats.BreakerRejects.Get(),
+		Hedges:         g.stats.Hedges.Get(),
+		HedgeWins:      g.stats.HedgeWins.Get(),
+		Pushes:         g.stats.Pushes.Get(),
+		PushErrors:     g.stats.PushErrors.Get(),
+		MainCache:      g.mainCache.stats(),
+		HotCache:       g.hotCache.stats(),
+	}
+	s.Evictions = s.MainCache.Evictions + s.HotCache.Evictions
+	s.Bytes = s.MainCache.Bytes + s.HotCache.Bytes
+	s.Items = s.MainCache.Items + s.HotCache.Items
+	return s
+}
+
 // RegisterPeers 注册一个 PeerPicker 用于选择远程Peer
 func (g *Group) RegisterPeers(peers PeerPicker) {
 	if g.peers != nil {
 		panic("RegisterPeerPicker called more than once")
 	}
 	g.peers = peers
+	if n, ok := peers.(PeerRemovalNotifier); ok { // 删除节点时一起删除它的熔断器
+		n.OnPeerRemoved(func(peer PeerGetter) {
+			g.breakers.Delete(peer)
+		})
+	}
 }
 
-func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
 	//bytes, err := peer.Get(g.name, key) // 获取数据 httpGetter 实现的 PeerGetter接口
 	//if err != nil {
 	//	return ByteView{}, err
@@ -163,11 +594,31 @@ func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
 	req := &pb.Request{
 		Group: g.name,
 		Key:   key,
+		Hops:  1, // 接收方不会再转发
 	}
 	res := &pb.Response{}
-	err := peer.Get(req, res)
+	err := g.callPeer(ctx, peer, func() error {
+		res.Reset() // 重试时丢弃上一次的结果
+		if pc, ok := peer.(PeerGetterContext); ok {
+			return pc.GetContext(ctx, req, res)
+		}
+		return peer.Get(req, res)
+	})
 	if err != nil {
 		return ByteView{}, err
 	}
-	return ByteView{b: res.Value}, nil
+	return g.peerValue(key, res), nil
+}
+
+// 把远程节点返回的 res 转换为 ByteView 并按概率放入热点缓存
+func (g *Group) peerValue(key string, res *pb.Response) ByteView {
+	value := ByteView{b: res.Value}
+	if res.Expire != 0 {
+		value.e = time.Unix(0, res.Expire) // 沿用远程节点上的过期时间
+	}
+	// 按概率放入热点缓存 被频繁访问的 key 很快就会命中
+	if g.hotRatio > 0 && rand.Float64() < g.hotProbability {
+		g.hotCache.add(key, value)
+	}
+	return value
 }
@@ -1,10 +1,16 @@
 package geecache
 
 import (
+	pb "Cache/geecache/geecachepb"
+	"Cache/lru"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetter(t *testing.T) {
@@ -55,4 +61,919 @@ func TestGet(t *testing.T)  {
 	if view, err := gee.Get("unknown"); err == nil {
 		t.Fatalf("the value of unkown should be empty, but %s get", view)
 	}
-}
\ No newline at end of file
+}
+
+func TestGetWithTTL(t *testing.T) {
+	loads := 0
+	gee := NewGroup("ttl", 2<<10, TTLGetterFunc(
+		func(key string) ([]byte, time.Duration, error) {
+			loads++
+			if key == "short" {
+				return []byte(key), 10 * time.Millisecond, nil // 单独指定 ttl
+			}
+			return []byte(key), 0, nil // 使用默认 ttl
+		}), WithTTL(time.Hour))
+
+	for _, k := range []string{"short", "long", "short", "long"} {
+		if view, err := gee.Get(k); err != nil || view.String() != k {
+			t.Fatalf("failed to get value of %s", k)
+		}
+	}
+	if loads != 2 {
+		t.Fatalf("expect 2 loads before expiration, got %d", loads)
+	}
+	time.Sleep(20 * time.Millisecond)
+	gee.Get("short")
+	gee.Get("long")
+	if loads != 3 {
+		t.Fatalf("expired key should be reloaded, got %d loads", loads)
+	}
+}
+
+func TestSetRemovePurge(t *testing.T) {
+	loads := 0
+	reasons := make(map[string]lru.EvictReason)
+	gee := NewGroup("write", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(db[key]), nil
+		}), WithEvicted(func(key string, value ByteView, reason lru.EvictReason) {
+		reasons[key] = reason
+	}))
+
+	gee.Get("Tom")
+	if err := gee.Set("Tom", []byte("700")); err != nil {
+		t.Fatal(err)
+	}
+	if view, _ := gee.Get("Tom"); view.String() != "700" || loads != 1 {
+		t.Fatalf("Set should overwrite cached value, got %s", view)
+	}
+	if err := gee.Remove("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if view, _ := gee.Get("Tom"); view.String() != db["Tom"] || loads != 2 {
+		t.Fatalf("removed key should be reloaded, got %s", view)
+	}
+	gee.Get("Jack")
+	gee.Purge()
+	if reasons["Tom"] != lru.EvictPurged || reasons["Jack"] != lru.EvictPurged {
+		t.Fatalf("unexpected evict reasons %v", reasons)
+	}
+	if err := gee.Remove(""); err == nil {
+		t.Fatal("empty key should be rejected")
+	}
+}
+
+// 回调在释放锁之后调用 回调中访问 group 不会死锁
+func TestEvictedReentrant(t *testing.T) {
+	var gee *Group
+	var got []string
+	gee = NewGroup("evicted-reentrant", int64(len("k1v1")), GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte("v" + key[1:]), nil
+		}), WithEvicted(func(key string, value ByteView, reason lru.EvictReason) {
+		if reason == lru.EvictCapacity {
+			v, _ := gee.Get("k2") // 淘汰 k1 的正是 k2 此时已在缓存中
+			got = append(got, key, v.String())
+			gee.Remove("k2")
+		}
+	}))
+	done := make(chan struct{})
+	go func() {
+		gee.Get("k1")
+		gee.Get("k2")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("calling the group from OnEvicted deadlocks")
+	}
+	if len(got) != 2 || got[0] != "k1" || got[1] != "v2" {
+		t.Fatalf("unexpected values seen in OnEvicted %v", got)
+	}
+}
+
+func TestGetContext(t *testing.T) {
+	gee := NewGroup("context", 2<<10, GetterContextFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			select {
+			case <-time.After(time.Second): // 模拟很慢的数据库
+				return []byte(key), nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := gee.GetContext(ctx, "Tom"); err != context.DeadlineExceeded {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("GetContext should return once ctx is done")
+	}
+	if _, ok := gee.mainCache.get("Tom"); ok {
+		t.Fatal("failed load should not be cached")
+	}
+}
+
+// 合并到同一次加载的调用者互不影响 第一个调用者取消后其他调用者仍能拿到结果
+func TestGetContextShared(t *testing.T) {
+	release := make(chan struct{})
+	gee := NewGroup("context-shared", 2<<10, GetterContextFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			select {
+			case <-release:
+				return []byte(key), nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := gee.GetContext(ctx, "Tom")
+		first <- err
+	}()
+	second := make(chan ByteView)
+	go func() {
+		time.Sleep(10 * time.Millisecond) // 等第一个调用者开始加载
+		v, _ := gee.GetContext(context.Background(), "Tom")
+		second <- v
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Fatalf("first caller should be canceled, got %v", err)
+	}
+	close(release)
+	if v := <-second; v.String() != "Tom" {
+		t.Fatalf("second caller should get the value, got %q", v)
+	}
+	if s := gee.Stats(); s.LocalLoads != 1 || s.Dedups != 1 {
+		t.Fatalf("callers should share one load, got %+v", s)
+	}
+}
+
+// 测试用的远程节点 所有 key 都由它负责 返回 key 本身
+type fakePeer struct {
+	gets int
+}
+
+func (p *fakePeer) PickPeer(key string) (PeerGetter, bool) {
+	return p, true
+}
+
+func (p *fakePeer) Get(in *pb.Request, out *pb.Response) error {
+	p.gets++
+	out.Value = []byte(in.GetKey())
+	return nil
+}
+
+func TestHotCache(t *testing.T) {
+	gee := NewGroup("hot", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s should be loaded from peer", key)
+		}), WithHotCache(0.25, 1))
+	if gee.hotCache.cacheBytes != 512 || gee.mainCache.(*cache).cacheBytes != 1536 {
+		t.Fatalf("unexpected split %d/%d", gee.hotCache.cacheBytes, gee.mainCache.(*cache).cacheBytes)
+	}
+	peer := &fakePeer{}
+	gee.RegisterPeers(peer)
+
+	for i := 0; i < 3; i++ {
+		if view, err := gee.Get("Tom"); err != nil || view.String() != "Tom" {
+			t.Fatalf("failed to get Tom from peer: %v", err)
+		}
+	}
+	if peer.gets != 1 {
+		t.Fatalf("hot key should be served locally, peer called %d times", peer.gets)
+	}
+	if _, ok := gee.mainCache.get("Tom"); ok {
+		t.Fatal("value owned by peer should not be stored in mainCache")
+	}
+	gee.removeLocally("Tom")
+	gee.Get("Tom")
+	if peer.gets != 2 {
+		t.Fatal("removed hot copy should be fetched again")
+	}
+}
+
+// ratio 不在 (0, 1) 范围内时不开启热点缓存 远程获取的值不会放入没有上限的热点缓存
+func TestHotCacheRatio(t *testing.T) {
+	for _, ratio := range []float64{1, 2, -1} {
+		gee := NewGroup("hot-ratio", 2<<10, GetterFunc(
+			func(key string) ([]byte, error) {
+				return nil, fmt.Errorf("%s should be loaded from peer", key)
+			}), WithHotCache(ratio, 1))
+		gee.RegisterPeers(&fakePeer{})
+		gee.Get("Tom")
+		if gee.mainCache.(*cache).cacheBytes != 2<<10 || gee.hotCache.stats().Items != 0 {
+			t.Fatalf("ratio %v should disable the hot cache", ratio)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	gee := NewGroup("stats", int64(len("Tom630")), GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}))
+	gee.Get("Tom")
+	gee.Get("Tom")
+	gee.Get("Sam") // 容量只够一条 Tom 被淘汰
+	gee.Get("unknown")
+
+	s := gee.Stats()
+	expect := Stats{Gets: 4, Hits: 1, Misses: 3, LocalLoads: 2, LocalLoadErrs: 1, Evictions: 1, Bytes: 6, Items: 1}
+	s.MainCache, s.HotCache = CacheStats{}, CacheStats{}
+	if s != expect {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	loads := 0
+	gee := NewGroup("negative", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist: %w", key, ErrNotFound)
+		}), WithNegativeTTL(20*time.Millisecond))
+
+	for i := 0; i < 3; i++ {
+		if _, err := gee.Get("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expect ErrNotFound, got %v", err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("not found result should be cached, got %d loads", loads)
+	}
+	time.Sleep(30 * time.Millisecond)
+	gee.Get("unknown")
+	if loads != 2 {
+		t.Fatalf("negative entry should expire, got %d loads", loads)
+	}
+	gee.Remove("unknown") // 写入数据库后删除缓存 "不存在"的结果同样被清除
+	gee.Get("unknown")
+	if loads != 3 {
+		t.Fatalf("negative entry should be removed, got %d loads", loads)
+	}
+}
+
+// 测试用的远程节点 总是返回 err
+type failingPeer struct {
+	err error
+}
+
+func (p *failingPeer) PickPeer(key string) (PeerGetter, bool) {
+	return p, true
+}
+
+func (p *failingPeer) Get(in *pb.Request, out *pb.Response) error {
+	return p.err
+}
+
+func TestPeerErrorFallback(t *testing.T) {
+	cases := []struct {
+		err      error
+		fallback bool
+	}{
+		{fmt.Errorf("connection refused"), true},
+		{&PeerError{Code: pb.Code_UNAVAILABLE}, true},
+		{&PeerError{Code: pb.Code_DEADLINE_EXCEEDED}, true},
+		{&PeerError{Code: pb.Code_INTERNAL}, false},
+		{&PeerError{Code: pb.Code_NOT_FOUND}, false},
+	}
+	for i, c := range cases {
+		loads := 0
+		gee := NewGroup(fmt.Sprintf("fallback-%d", i), 2<<10, GetterFunc(
+			func(key string) ([]byte, error) {
+				loads++
+				return []byte(key), nil
+			}))
+		gee.RegisterPeers(&failingPeer{err: c.err})
+		_, err := gee.Get("Tom")
+		if c.fallback && (err != nil || loads != 1) || !c.fallback && (err == nil || loads != 0) {
+			t.Errorf("%v: fallback should be %v, got err %v after %d loads", c.err, c.fallback, err, loads)
+		}
+	}
+	if !errors.Is(&PeerError{Code: pb.Code_NOT_FOUND}, ErrNotFound) {
+		t.Error("NOT_FOUND from peer should match ErrNotFound")
+	}
+}
+
+// 测试用的批量回调函数 没有的 key 不出现在结果中
+type batchGetter struct {
+	calls int
+}
+
+func (g *batchGetter) Get(key string) ([]byte, error) {
+	return nil, fmt.Errorf("Get should not be called for %s", key)
+}
+
+func (g *batchGetter) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	g.calls++
+	res := make(map[string][]byte)
+	for _, key := range keys {
+		if v, ok := db[key]; ok {
+			res[key] = []byte(v)
+		}
+	}
+	return res, nil
+}
+
+// 测试用的带 ttl 的批量回调函数 Get 等到 release 关闭后才返回
+type ttlBatchGetter struct {
+	release chan struct{}
+	batches [][]string
+}
+
+func (g *ttlBatchGetter) Get(key string) ([]byte, error) {
+	<-g.release
+	return []byte(key), nil
+}
+
+func (g *ttlBatchGetter) GetMultiWithTTL(ctx context.Context, keys []string) (map[string][]byte, map[string]time.Duration, error) {
+	g.batches = append(g.batches, keys)
+	values := make(map[string][]byte)
+	for _, key := range keys {
+		values[key] = []byte(key)
+	}
+	return values, map[string]time.Duration{"short": time.Minute}, nil
+}
+
+// 批量加载与 Get 共享进行中的加载 并使用每个 key 的 ttl
+func TestGetMultiLocally(t *testing.T) {
+	getter := &ttlBatchGetter{release: make(chan struct{})}
+	gee := NewGroup("multi-local", 2<<10, getter, WithTTL(time.Hour))
+	done := make(chan struct{})
+	go func() {
+		gee.Get("Tom")
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond) // 等 Get 开始加载 Tom
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(getter.release)
+	}()
+	values, err := gee.GetMulti([]string{"Tom", "short", "long"})
+	<-done
+	if err != nil || len(values) != 3 || values["Tom"].String() != "Tom" {
+		t.Fatalf("unexpected result %v %v", values, err)
+	}
+	if len(getter.batches) != 1 || len(getter.batches[0]) != 2 {
+		t.Fatalf("Tom should share the load started by Get, batches %v", getter.batches)
+	}
+	if s := gee.Stats(); s.Dedups != 1 || s.LocalLoads != 3 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if left := time.Until(values["short"].Expire()); left > time.Minute {
+		t.Fatalf("short should use its own ttl, expires in %v", left)
+	}
+	if left := time.Until(values["long"].Expire()); left < 59*time.Minute {
+		t.Fatalf("long should use the default ttl, expires in %v", left)
+	}
+}
+
+// 测试用的远程节点 负责 remote- 开头的 key remote-bad 返回 INTERNAL remote-down 返回 UNAVAILABLE
+type batchPeer struct {
+	batches int
+}
+
+func (p *batchPeer) PickPeer(key string) (PeerGetter, bool) {
+	return p, strings.HasPrefix(key, "remote-")
+}
+
+func (p *batchPeer) Get(in *pb.Request, out *pb.Response) error {
+	return fmt.Errorf("Get should not be called for %s", in.GetKey())
+}
+
+func (p *batchPeer) GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	p.batches++
+	for _, key := range in.GetKeys() {
+		res := &pb.Response{Value: []byte(key)}
+		switch key {
+		case "remote-bad":
+			res = &pb.Response{Code: pb.Code_INTERNAL, Message: "load failed"}
+		case "remote-down":
+			res = &pb.Response{Code: pb.Code_UNAVAILABLE, Message: "shutting down"}
+		}
+		out.Responses = append(out.Responses, res)
+	}
+	return nil
+}
+
+func TestGetMulti(t *testing.T) {
+	getter := &batchGetter{}
+	peer := &batchPeer{}
+	gee := NewGroup("multi", 2<<10, getter)
+	gee.RegisterPeers(peer)
+	gee.Set("Sam", []byte("123"))
+
+	keys := []string{"Tom", "Sam", "unknown", "Tom", "remote-a", "remote-b", "remote-bad", "remote-down"}
+	values, err := gee.GetMulti(keys)
+	errs, ok := err.(MultiError)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("expect 3 failed keys, got %v", err)
+	}
+	if !errors.Is(errs["unknown"], ErrNotFound) {
+		t.Errorf("unknown should be ErrNotFound, got %v", errs["unknown"])
+	}
+	if codeOf(errs["remote-bad"]) != pb.Code_INTERNAL {
+		t.Errorf("remote-bad should not fall back, got %v", errs["remote-bad"])
+	}
+	if !errors.Is(errs["remote-down"], ErrNotFound) {
+		t.Errorf("remote-down should fall back to the getter, got %v", errs["remote-down"])
+	}
+	expect := map[string]string{"Tom": "630", "Sam": "123", "remote-a": "remote-a", "remote-b": "remote-b"}
+	if len(values) != len(expect) {
+		t.Fatalf("expect %d values, got %d", len(expect), len(values))
+	}
+	for key, v := range expect {
+		if values[key].String() != v {
+			t.Errorf("%s: expect %s, got %s", key, v, values[key].String())
+		}
+	}
+	// 一个远程节点一次请求 本地的 key 一次加载
+	if peer.batches != 1 || getter.calls != 1 {
+		t.Fatalf("expect 1 batch and 1 load, got %d and %d", peer.batches, getter.calls)
+	}
+
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("Tom should be cached by GetMulti, got %v %v", v, err)
+	}
+	if getter.calls != 1 {
+		t.Fatalf("Get should hit the cache, got %d loads", getter.calls)
+	}
+}
+
+// 等待 cond 成立 最多等待 1 秒
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for background refresh")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var loads AtomicInt
+	gee := NewGroup("stale", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads.Add(1)
+			return []byte(fmt.Sprintf("%s-v%d", key, loads.Get())), nil
+		}), WithTTL(20*time.Millisecond), WithStaleWhileRevalidate(time.Hour))
+
+	if v, _ := gee.Get("Tom"); v.String() != "Tom-v1" {
+		t.Fatalf("expect Tom-v1, got %s", v)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if v, _ := gee.Get("Tom"); v.String() != "Tom-v1" { // 过期后立即返回旧值
+		t.Fatalf("expect stale Tom-v1, got %s", v)
+	}
+	waitFor(t, func() bool {
+		v, _ := gee.Get("Tom")
+		return v.String() == "Tom-v2"
+	})
+	if s := gee.Stats(); s.StaleHits < 1 || s.Refreshes != 1 || loads.Get() != 2 {
+		t.Fatalf("expect one background refresh, got %d refreshes %d loads", s.Refreshes, loads.Get())
+	}
+}
+
+// 缓存的"不存在"过期后不作为旧值返回 而是同步重新加载
+func TestStaleNotFound(t *testing.T) {
+	var loads AtomicInt
+	gee := NewGroup("stale-missing", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads.Add(1)
+			if loads.Get() == 1 {
+				return nil, ErrNotFound
+			}
+			return []byte(key), nil
+		}), WithNegativeTTL(20*time.Millisecond), WithStaleWhileRevalidate(time.Hour))
+
+	if _, err := gee.Get("Tom"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "Tom" {
+		t.Fatalf("expired not-found should be reloaded, got %v %v", v, err)
+	}
+	if s := gee.Stats(); s.StaleHits != 0 || s.Refreshes != 0 {
+		t.Fatalf("not-found should not be served stale, got %+v", s)
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	var loads AtomicInt
+	gee := NewGroup("refresh-ahead", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads.Add(1)
+			return []byte(fmt.Sprintf("%s-v%d", key, loads.Get())), nil
+		}), WithTTL(time.Hour), WithRefreshAhead(time.Minute))
+
+	gee.Get("Tom")
+	gee.Get("Tom") // 剩余时间远大于 1 分钟 不刷新
+	if loads.Get() != 1 {
+		t.Fatalf("expect 1 load, got %d", loads.Get())
+	}
+
+	gee.mainCache.add("Tom", ByteView{b: []byte("old"), e: time.Now().Add(time.Second)})
+	if v, _ := gee.Get("Tom"); v.String() != "old" { // 快要过期 仍返回当前值
+		t.Fatalf("expect old, got %s", v)
+	}
+	waitFor(t, func() bool {
+		v, _ := gee.Get("Tom")
+		return v.String() == "Tom-v2"
+	})
+	if s := gee.Stats(); s.StaleHits != 0 || s.Refreshes != 1 {
+		t.Fatalf("expect 1 refresh and no stale hits, got %+v", s)
+	}
+}
+
+func TestWithPolicy(t *testing.T) {
+	var evicted []string
+	gee := NewGroup("policy", int64(len("Tom630Jack345")), GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}), WithPolicy(lru.LFU), WithEvicted(func(key string, value ByteView, reason lru.EvictReason) {
+		if reason == lru.EvictCapacity {
+			evicted = append(evicted, key)
+		}
+	}))
+	gee.Get("Tom")
+	gee.Get("Tom")
+	gee.Get("Jack")
+	gee.Get("Sam") // LRU 会淘汰 Tom LFU 淘汰访问次数更少的 Jack
+	if !reflect.DeepEqual(evicted, []string{"Jack"}) {
+		t.Fatalf("expect Jack to be evicted, got %v", evicted)
+	}
+}
+
+// 测试用的远程节点 前 fails 次请求返回网络错误 之后返回 key 本身
+type flakyPeer struct {
+	calls int
+	fails int
+}
+
+func (p *flakyPeer) PickPeer(key string) (PeerGetter, bool) {
+	return p, true
+}
+
+func (p *flakyPeer) Get(in *pb.Request, out *pb.Response) error {
+	p.calls++
+	if p.calls <= p.fails {
+		return fmt.Errorf("connection refused")
+	}
+	out.Value = []byte(in.GetKey())
+	return nil
+}
+
+func TestRetry(t *testing.T) {
+	loads := 0
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("local"), nil
+	})
+	gee := NewGroup("retry", 2<<10, getter, WithRetry(2, time.Millisecond))
+	peer := &flakyPeer{fails: 2}
+	gee.RegisterPeers(peer)
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "Tom" {
+		t.Fatalf("expect Tom from peer after retries, got %v %v", v, err)
+	}
+	if peer.calls != 3 || loads != 0 || gee.Stats().PeerRetries != 2 {
+		t.Fatalf("expect 3 calls without local load, got %d calls %d loads", peer.calls, loads)
+	}
+
+	// 远程节点返回的错误不是暂时性的 不重试
+	gee = NewGroup("retry-internal", 2<<10, getter, WithRetry(2, time.Millisecond))
+	failing := &failingPeer{err: &PeerError{Code: pb.Code_INTERNAL}}
+	gee.RegisterPeers(failing)
+	if _, err := gee.Get("Tom"); err == nil || gee.Stats().PeerRetries != 0 {
+		t.Fatalf("INTERNAL should not be retried, got %v", err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	loads := 0
+	gee := NewGroup("breaker", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte("local"), nil
+		}), WithCircuitBreaker(2, 20*time.Millisecond))
+	peer := &flakyPeer{fails: 3}
+	gee.RegisterPeers(peer)
+
+	gee.Get("k1")
+	gee.Get("k2") // 连续失败两次 熔断器打开
+	gee.Get("k3") // 不再请求远程节点
+	if peer.calls != 2 || loads != 3 || gee.Stats().BreakerRejects != 1 {
+		t.Fatalf("expect breaker to open after 2 failures, got %d calls %d loads", peer.calls, loads)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	gee.Get("k4") // 半开 试探请求失败 重新打开
+	gee.Get("k5")
+	if peer.calls != 3 || loads != 5 {
+		t.Fatalf("failed probe should reopen the breaker, got %d calls %d loads", peer.calls, loads)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if v, _ := gee.Get("k6"); v.String() != "k6" { // 试探成功 熔断器关闭
+		t.Fatalf("expect k6 from peer, got %s", v)
+	}
+	if v, _ := gee.Get("k7"); v.String() != "k7" || peer.calls != 5 {
+		t.Fatalf("breaker should be closed, got %s after %d calls", v, peer.calls)
+	}
+}
+
+// 调用方取消的试探请求不能说明节点已恢复 熔断器保持半开 下一个请求继续试探
+func TestBreakerCanceledProbe(t *testing.T) {
+	gee := NewGroup("breaker-cancel", 2<<10, GetterFunc(func(key string) ([]byte, error) { return []byte(key), nil }), WithCircuitBreaker(1, 10*time.Millisecond))
+	peer := &flakyPeer{}
+	b := gee.breaker(peer)
+	b.done(true)
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := gee.callPeer(ctx, peer, func() error { return ctx.Err() })
+	if err != context.Canceled || b.state != breakerHalfOpen || b.probing {
+		t.Fatalf("canceled probe should keep the breaker half-open, got %v state %v", err, b.state)
+	}
+	if err = gee.callPeer(context.Background(), peer, func() error { return nil }); err != nil || b.state != breakerClosed {
+		t.Fatalf("expect next probe to close the breaker, got %v state %v", err, b.state)
+	}
+}
+
+// 测试用的远程节点 等待 delay 后返回 name
+type slowPeer struct {
+	name  string
+	delay time.Duration
+}
+
+func (p *slowPeer) Get(in *pb.Request, out *pb.Response) error {
+	return p.GetContext(context.Background(), in, out)
+}
+
+func (p *slowPeer) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	select {
+	case <-time.After(p.delay):
+		out.Value = []byte(p.name)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 按固定顺序返回节点的 PeersPicker
+type orderedPicker []PeerGetter
+
+func (p orderedPicker) PickPeer(key string) (PeerGetter, bool) {
+	return p[0], true
+}
+
+func (p orderedPicker) PickPeers(key string, n int) []PeerGetter {
+	if n > len(p) {
+		n = len(p)
+	}
+	return p[:n]
+}
+
+// PickPeer 选择第二个节点 模拟有界负载把 key 交给了下一个节点
+type spilledPicker struct {
+	orderedPicker
+}
+
+func (p spilledPicker) PickPeer(key string) (PeerGetter, bool) {
+	return p.orderedPicker[1], true
+}
+
+// 主请求发给了第二个节点时 对冲请求发给第一个节点 而不是同一个节点
+func TestHedgeSpilled(t *testing.T) {
+	gee := NewGroup("hedge-spilled", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	}), WithHedgeDelay(10*time.Millisecond))
+	gee.RegisterPeers(spilledPicker{orderedPicker{&slowPeer{name: "owner"}, &slowPeer{name: "spilled", delay: time.Second}}})
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "owner" {
+		t.Fatalf("hedge should go to the owner, got %v %v", v, err)
+	}
+}
+
+func TestHedgedRequest(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	})
+	primary := &slowPeer{name: "primary", delay: time.Second}
+	cases := []struct {
+		name   string
+		picker orderedPicker
+		expect string
+	}{
+		{"hedge-peer", orderedPicker{primary, &slowPeer{name: "secondary"}}, "secondary"},
+		{"hedge-local", orderedPicker{primary, nil}, "local"}, // 第二个节点是自己
+		{"hedge-fast", orderedPicker{&slowPeer{name: "primary"}, &slowPeer{name: "secondary"}}, "primary"},
+	}
+	for _, c := range cases {
+		gee := NewGroup(c.name, 2<<10, getter, WithHedgeDelay(10*time.Millisecond))
+		gee.RegisterPeers(c.picker)
+		start := time.Now()
+		v, err := gee.Get("Tom")
+		if err != nil || v.String() != c.expect {
+			t.Fatalf("%s: expect %s, got %v %v", c.name, c.expect, v, err)
+		}
+		if time.Since(start) > 500*time.Millisecond {
+			t.Fatalf("%s: hedged request should not wait for the slow primary", c.name)
+		}
+		s := gee.Stats()
+		if hedged := c.expect != "primary"; s.Hedges != s.HedgeWins || (s.Hedges == 1) != hedged {
+			t.Fatalf("%s: unexpected hedges %d wins %d", c.name, s.Hedges, s.HedgeWins)
+		}
+	}
+}
+
+// 记录收到的请求的 Hops 的 slowPeer
+type hopsPeer struct {
+	slowPeer
+	hops chan uint32
+}
+
+func (p *hopsPeer) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	p.hops <- in.GetHops()
+	return p.slowPeer.GetContext(ctx, in, out)
+}
+
+// 对冲请求发出后主节点先返回 不算对冲成功
+// 发给第二个节点的请求带有 Hops 第二个节点不会再转发回主节点
+func TestHedgePrimaryWins(t *testing.T) {
+	gee := NewGroup("hedge-primary-wins", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	}), WithHedgeDelay(10*time.Millisecond))
+	secondary := &hopsPeer{slowPeer{name: "secondary", delay: time.Second}, make(chan uint32, 1)}
+	gee.RegisterPeers(orderedPicker{&slowPeer{name: "primary", delay: 50 * time.Millisecond}, secondary})
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "primary" {
+		t.Fatalf("expect primary, got %v %v", v, err)
+	}
+	if s := gee.Stats(); s.Hedges != 1 || s.HedgeWins != 0 {
+		t.Fatalf("primary won, expect 1 hedge and no wins, got %d %d", s.Hedges, s.HedgeWins)
+	}
+	if hops := <-secondary.hops; hops != 1 {
+		t.Fatalf("hedged request should be marked as forwarded, got hops %d", hops)
+	}
+}
+
+// 记录收到的推送的远程节点
+type pushPeer struct {
+	slowPeer
+	pushed chan *pb.PushRequest
+}
+
+func newPushPeer(name string) *pushPeer {
+	return &pushPeer{slowPeer: slowPeer{name: name}, pushed: make(chan *pb.PushRequest, 1)}
+}
+
+func (p *pushPeer) Push(ctx context.Context, in *pb.PushRequest) error {
+	p.pushed <- in
+	return nil
+}
+
+// 按固定顺序返回副本的 ReplicaPicker nil 表示自己
+type replicaPicker []PeerGetter
+
+func (p replicaPicker) PickPeer(key string) (PeerGetter, bool) {
+	return p[0], p[0] != nil
+}
+
+func (p replicaPicker) PickReplicas(key string) []PeerGetter {
+	return p
+}
+
+func TestReplicas(t *testing.T) {
+	loads := 0
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("local"), nil
+	})
+	a, b := newPushPeer("a"), newPushPeer("b")
+	cases := []struct {
+		name     string
+		replicas replicaPicker
+		expect   string
+		loads    int
+		pushes   []*pushPeer
+	}{
+		{"replica-primary", replicaPicker{nil, a, b}, "local", 1, []*pushPeer{a, b}}, // 自己是主节点 加载后推送
+		{"replica-failover", replicaPicker{&flakyPeer{fails: 1}, b}, "b", 0, nil},    // 主节点宕机 由下一个副本提供
+		{"replica-secondary", replicaPicker{&flakyPeer{fails: 1}, nil, b}, "local", 1, nil},
+	}
+	for _, c := range cases {
+		loads = 0
+		gee := NewGroup(c.name, 2<<10, getter)
+		gee.RegisterPeers(c.replicas)
+		if v, err := gee.Get("Tom"); err != nil || v.String() != c.expect || loads != c.loads {
+			t.Fatalf("%s: expect %s with %d loads, got %v %v %d", c.name, c.expect, c.loads, v, err, loads)
+		}
+		for _, p := range c.pushes {
+			select {
+			case req := <-p.pushed:
+				if req.GetGroup() != c.name || req.GetKey() != "Tom" || string(req.GetValue()) != "local" {
+					t.Fatalf("%s: unexpected push %v", c.name, req)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s: expect a push to %s", c.name, p.name)
+			}
+		}
+		if s := gee.Stats(); s.Pushes != int64(len(c.pushes)) {
+			t.Fatalf("%s: expect %d pushes, got %d", c.name, len(c.pushes), s.Pushes)
+		}
+	}
+}
+
+// 多副本与对冲和批量查询一起使用
+func TestReplicasHedgeAndMulti(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	})
+	gee := NewGroup("replica-hedge", 2<<10, getter, WithHedgeDelay(10*time.Millisecond))
+	gee.RegisterPeers(replicaPicker{&slowPeer{name: "primary", delay: time.Second}, nil})
+	start := time.Now()
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "local" || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("expect a hedged local load, got %v %v", v, err)
+	}
+
+	gee = NewGroup("replica-multi-failover", 2<<10, getter)
+	gee.RegisterPeers(replicaPicker{&flakyPeer{fails: 1}, newPushPeer("b")})
+	if values, err := gee.GetMulti([]string{"Tom"}); err != nil || values["Tom"].String() != "b" {
+		t.Fatalf("expect Tom from the second replica, got %v %v", values, err)
+	}
+
+	a := newPushPeer("a")
+	gee = NewGroup("replica-multi-push", 2<<10, getter)
+	gee.RegisterPeers(replicaPicker{nil, a})
+	if _, err := gee.GetMulti([]string{"Tom"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case req := <-a.pushed:
+		if req.GetKey() != "Tom" {
+			t.Fatalf("unexpected push %v", req)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect a push after the batch load")
+	}
+}
+
+// 一直阻塞的 PeerPusher
+type blockingPusher struct {
+	slowPeer
+	release chan struct{}
+}
+
+func (p *blockingPusher) Push(ctx context.Context, in *pb.PushRequest) error {
+	<-p.release
+	return nil
+}
+
+func TestPushLimit(t *testing.T) {
+	gee := NewGroup("push-limit", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	p := &blockingPusher{release: make(chan struct{})}
+	peers := make([]PeerGetter, maxPushes+1)
+	for i := range peers {
+		peers[i] = p
+	}
+	gee.pushToReplicas("Tom", ByteView{b: []byte("630")}, peers)
+	if s := gee.Stats(); s.Pushes != maxPushes || s.PushErrors != 1 {
+		t.Fatalf("expect %d pushes and 1 dropped, got %d %d", maxPushes, s.Pushes, s.PushErrors)
+	}
+	close(p.release)
+}
+
+// 删除之前发出的推送在删除之后到达时被丢弃
+func TestStalePush(t *testing.T) {
+	gee := NewGroup("push-stale", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("source"), nil
+	}))
+	gee.Remove("Tom")
+	if gee.populatePushed("Tom", ByteView{b: []byte("old")}) {
+		t.Fatal("push of a removed key should be dropped")
+	}
+	if v, _ := gee.Get("Tom"); v.String() != "source" {
+		t.Fatalf("expect Tom from the source, got %s", v)
+	}
+	if !gee.populatePushed("Jack", ByteView{b: []byte("589")}) {
+		t.Fatal("push of other keys should be kept")
+	}
+}
@@ -0,0 +1,356 @@
+package geecache
+
+import (
+	"Cache/geecache/consistenthash"
+	pb "Cache/geecache/geecachepb"
+	"context"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+基于 gRPC 的节点通信 和 HTTPPool 二选一使用
+geecachepb.proto 中定义的 GroupCache 服务由 GRPCPool 提供
+每个远程节点对应一个 grpcGetter 复用同一条长连接（HTTP/2 多路复用）
+*/
+
+const (
+	// 通过 metadata 告诉服务端请求来自哪个节点
+	peerMetadataKey = "geecache-peer"
+	// 服务端返回错误时在 trailer 中带上 pb.Code 客户端据此区分节点返回的错误和传输层的错误
+	codeMetadataKey = "geecache-code"
+)
+
+// GRPCPoolOptions 是 GRPCPool 的可选配置 零值字段使用默认值
+type GRPCPoolOptions struct {
+	// 一致性哈希的虚拟节点倍数 默认为 defaultReplicas
+	Replicas int
+	// 每次远程调用的超时时间 <=0 表示不设置超时
+	Timeout time.Duration
+	// 建立连接时使用的选项 默认为 grpc.WithInsecure()
+	DialOptions []grpc.DialOption
+	// 每次调用附带的 metadata 会额外带上 geecache-peer: self
+	Metadata metadata.MD
+}
+
+type GRPCPool struct {
+	self string // 自己的地址 如 localhost:8001
+	opts GRPCPoolOptions
+
+	mu          sync.Mutex             // 保护 peers 和 grpcGetters
+	peers       *consistenthash.Map    // 一致性哈希算法的Map 根据 key 选择节点
+	grpcGetters map[string]*grpcGetter // keyed by e.g. "10.0.0.2:8008"
+	members     membership             // 节点列表的版本 随请求发送给远程节点
+	removed     removalHooks           // 节点被删除后通知 见 OnPeerRemoved
+}
+
+// NewGRPCPool 创建 GRPCPool o 为 nil 时全部使用默认配置
+func NewGRPCPool(self string, o *GRPCPoolOptions) *GRPCPool {
+	p := &GRPCPool{self: self}
+	if o != nil {
+		p.opts = *o
+	}
+	if p.opts.Replicas == 0 {
+		p.opts.Replicas = defaultReplicas
+	}
+	if p.opts.DialOptions == nil {
+		p.opts.DialOptions = []grpc.DialOption{grpc.WithInsecure()}
+	}
+	p.opts.Metadata = metadata.Join(p.opts.Metadata, metadata.Pairs(peerMetadataKey, self))
+	return p
+}
+
+func (p *GRPCPool) Log(format string, v ...interface{}) {
+	log.Printf("[Server %s] %s", p.self, fmt.Sprintf(format, v...))
+}
+
+// Register 把 GroupCache 服务注册到 s 上 之后由调用方执行 s.Serve(lis)
+func (p *GRPCPool) Register(s *grpc.Server) {
+	pb.RegisterGroupCacheServer(s, &grpcServer{pool: p})
+}
+
+// Set 更新节点列表 已有节点的连接会被复用 不再存在的节点的连接会被关闭
+func (p *GRPCPool) Set(peers ...string) {
+	p.mu.Lock()
+	p.peers = consistenthash.New(p.opts.Replicas, nil)
+	p.peers.Add(peers...)
+	getters := make(map[string]*grpcGetter, len(peers))
+	for _, peer := range peers {
+		if g, ok := p.grpcGetters[peer]; ok {
+			getters[peer] = g
+			delete(p.grpcGetters, peer)
+			continue
+		}
+		getters[peer] = &grpcGetter{addr: peer, pool: p}
+	}
+	var removed []PeerGetter
+	for _, g := range p.grpcGetters {
+		g.close()
+		removed = append(removed, g)
+	}
+	p.grpcGetters = getters
+	list := make([]string, 0, len(getters))
+	for peer := range getters {
+		list = append(list, peer)
+	}
+	p.members.set(list)
+	p.mu.Unlock()
+	p.removed.notify(removed)
+}
+
+// OnPeerRemoved 注册一个回调 Set 删除节点后调用 实现 PeerRemovalNotifier
+func (p *GRPCPool) OnPeerRemoved(fn func(peer PeerGetter)) {
+	p.removed.add(fn)
+}
+
+// 检查远程节点发来的节点列表版本 返回处理请求使用的 ctx 语义与 HTTPPool 相同
+func (p *GRPCPool) requestContext(ctx context.Context, hops uint32, version string) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	p.members.check(version, strings.Join(md.Get(peerMetadataKey), ","), p.Log)
+	if hops > 0 {
+		return withForwarded(ctx)
+	}
+	return ctx
+}
+
+// PickPeer 根据 key 选择节点 返回节点对应的 gRPC 客户端
+func (p *GRPCPool) PickPeer(key string) (PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, false
+	}
+	if peer := p.peers.Get(key); peer != "" && peer != p.self {
+		p.Log("Pick peer %s", peer)
+		return p.grpcGetters[peer], true
+	}
+	return nil, false
+}
+
+// PickPeers 返回负责 key 的前 n 个节点对应的 gRPC 客户端 自己对应的元素为 nil
+func (p *GRPCPool) PickPeers(key string, n int) []PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil
+	}
+	nodes := p.peers.GetN(key, n)
+	getters := make([]PeerGetter, len(nodes))
+	for i, node := range nodes {
+		if getter, ok := p.grpcGetters[node]; ok && node != p.self {
+			getters[i] = getter
+		}
+	}
+	return getters
+}
+
+// Invalidate 把删除广播给除自己以外的所有节点 语义与 HTTPPool.Invalidate 相同
+func (p *GRPCPool) Invalidate(group string, key string) error {
+	p.mu.Lock()
+	peers := make(map[string]invalidator, len(p.grpcGetters))
+	for peer, getter := range p.grpcGetters {
+		if peer != p.self {
+			peers[peer] = getter
+		}
+	}
+	p.mu.Unlock()
+	return broadcastInvalidate(peers, &pb.InvalidateRequest{Group: group, Key: key}, p.Log)
+}
+
+// Close 关闭所有远程节点的连接
+func (p *GRPCPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, g := range p.grpcGetters {
+		g.close()
+	}
+	p.grpcGetters = nil
+	p.peers = nil
+}
+
+var _ PeerPicker = (*GRPCPool)(nil)
+var _ PeerInvalidator = (*GRPCPool)(nil)
+var _ PeersPicker = (*GRPCPool)(nil)
+
+/* 下面是服务端 */
+
+// grpcServer 实现 pb.GroupCacheServer
+// 单独定义一个类型 是因为服务端的 Invalidate 与 PeerInvalidator 的 Invalidate 签名不同
+type grpcServer struct {
+	pool *GRPCPool
+}
+
+func (s *grpcServer) Get(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.pool.Log("Get %s/%s from %v", in.GetGroup(), in.GetKey(), md.Get(peerMetadataKey))
+	group := GetGroup(in.GetGroup())
+	if group == nil {
+		return nil, peerStatus(ctx, pb.Code_UNAVAILABLE, "no such group:"+in.GetGroup())
+	}
+	view, err := group.GetContext(s.pool.requestContext(ctx, in.GetHops(), in.GetRingVersion()), in.GetKey())
+	if err != nil {
+		return nil, peerStatus(ctx, codeOf(err), err.Error())
+	}
+	return &pb.Response{Value: view.ByteSlice(), Expire: view.expireUnixNano()}, nil
+}
+
+func (s *grpcServer) GetMulti(ctx context.Context, in *pb.BatchRequest) (*pb.BatchResponse, error) {
+	group := GetGroup(in.GetGroup())
+	if group == nil {
+		return nil, peerStatus(ctx, pb.Code_UNAVAILABLE, "no such group:"+in.GetGroup())
+	}
+	res, _ := serveBatch(s.pool.requestContext(ctx, in.GetHops(), in.GetRingVersion()), group, in.GetKeys())
+	return res, nil
+}
+
+func (s *grpcServer) Invalidate(ctx context.Context, in *pb.InvalidateRequest) (*pb.InvalidateResponse, error) {
+	group := GetGroup(in.GetGroup())
+	if group == nil {
+		return nil, peerStatus(ctx, pb.Code_UNAVAILABLE, "no such group:"+in.GetGroup())
+	}
+	group.removeLocally(in.GetKey())
+	return &pb.InvalidateResponse{}, nil
+}
+
+/* 下面实现客户端 */
+type grpcGetter struct {
+	addr string
+	pool *GRPCPool
+
+	mu   sync.Mutex
+	conn *grpc.ClientConn // 延迟建立 之后一直复用
+}
+
+// 返回到远程节点的客户端 第一次调用时建立连接
+func (g *grpcGetter) client() (pb.GroupCacheClient, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.conn == nil {
+		conn, err := grpc.Dial(g.addr, g.pool.opts.DialOptions...)
+		if err != nil {
+			return nil, err
+		}
+		g.conn = conn
+	}
+	return pb.NewGroupCacheClient(g.conn), nil
+}
+
+// 在 parent 的基础上为每次调用设置超时和 metadata
+func (g *grpcGetter) context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx := metadata.NewOutgoingContext(parent, g.pool.opts.Metadata)
+	if g.pool.opts.Timeout > 0 {
+		return context.WithTimeout(ctx, g.pool.opts.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (g *grpcGetter) Get(in *pb.Request, out *pb.Response) error {
+	return g.GetContext(context.Background(), in, out)
+}
+
+// GetContext 与 Get 相同 调用方的 deadline 与 Timeout 取较早的一个
+func (g *grpcGetter) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	client, err := g.client()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := g.context(ctx)
+	defer cancel()
+	in.RingVersion = g.pool.members.get()
+	var trailer metadata.MD
+	res, err := client.Get(ctx, in, grpc.Trailer(&trailer))
+	if err != nil {
+		return g.peerError(err, trailer)
+	}
+	proto.Merge(out, res)
+	return nil
+}
+
+// GetMulti 一次调用获取多个 key 单个 key 的错误在对应的 Response.Code 中
+func (g *grpcGetter) GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	client, err := g.client()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := g.context(ctx)
+	defer cancel()
+	in.RingVersion = g.pool.members.get()
+	var trailer metadata.MD
+	res, err := client.GetMulti(ctx, in, grpc.Trailer(&trailer))
+	if err != nil {
+		return g.peerError(err, trailer)
+	}
+	proto.Merge(out, res)
+	return nil
+}
+
+func (g *grpcGetter) invalidate(in *pb.InvalidateRequest) error {
+	client, err := g.client()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := g.context(context.Background())
+	defer cancel()
+	_, err = client.Invalidate(ctx, in)
+	return err
+}
+
+func (g *grpcGetter) close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.conn != nil {
+		g.conn.Close()
+		g.conn = nil
+	}
+}
+
+// pb.Code 与 gRPC 状态码之间的映射
+func grpcCode(code pb.Code) codes.Code {
+	switch code {
+	case pb.Code_OK:
+		return codes.OK
+	case pb.Code_NOT_FOUND:
+		return codes.NotFound
+	case pb.Code_UNAVAILABLE:
+		return codes.Unavailable
+	case pb.Code_DEADLINE_EXCEEDED:
+		return codes.DeadlineExceeded
+	case pb.Code_INVALID_ARGUMENT:
+		return codes.InvalidArgument
+	}
+	return codes.Internal
+}
+
+// 服务端返回的错误 在 trailer 中带上 pb.Code
+func peerStatus(ctx context.Context, code pb.Code, msg string) error {
+	grpc.SetTrailer(ctx, metadata.Pairs(codeMetadataKey, code.String()))
+	return status.Error(grpcCode(code), msg)
+}
+
+// 把调用失败的错误还原为 *PeerError
+// trailer 中有 pb.Code 时是远程节点返回的错误 否则来自传输层或者代理（例如代理返回的 HTTP 400 帧错误）
+// 此时节点不一定处理过请求 除了超时都视为 UNAVAILABLE 可以回退到本地加载
+func (g *grpcGetter) peerError(err error, trailer metadata.MD) error {
+	st := status.Convert(err)
+	code := pb.Code_UNAVAILABLE
+	if v := trailer.Get(codeMetadataKey); len(v) > 0 {
+		if c, ok := pb.Code_value[v[0]]; ok {
+			code = pb.Code(c)
+		}
+	} else if st.Code() == codes.DeadlineExceeded {
+		code = pb.Code_DEADLINE_EXCEEDED
+	}
+	return &PeerError{Peer: g.addr, Code: code, Message: st.Message()}
+}
+
+var _ PeerGetter = (*grpcGetter)(nil)
+var _ PeerGetterContext = (*grpcGetter)(nil)
+var _ BatchPeerGetter = (*grpcGetter)(nil)
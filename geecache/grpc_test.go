@@ -0,0 +1,107 @@
+package geecache
+
+import (
+	pb "Cache/geecache/geecachepb"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGRPCPool(t *testing.T) {
+	loads := 0
+	gee := NewGroup("grpc", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(key), nil
+		}))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewGRPCPool(lis.Addr().String(), nil)
+	s := grpc.NewServer()
+	server.Register(s)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	// client 只知道 server 一个节点 所有 key 都会发往 server
+	client := NewGRPCPool("client", &GRPCPoolOptions{Timeout: time.Second})
+	client.Set(lis.Addr().String())
+	defer client.Close()
+	peer, ok := client.PickPeer("Tom")
+	if !ok {
+		t.Fatal("expect to pick remote peer for Tom")
+	}
+	res := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: gee.name, Key: "Tom"}, res); err != nil || string(res.Value) != "Tom" {
+		t.Fatalf("grpc get failed: %v %q", err, res.Value)
+	}
+	if err := peer.Get(&pb.Request{Group: "unknown", Key: "Tom"}, res); err == nil {
+		t.Fatal("expect error for unknown group")
+	}
+	if err := client.Invalidate(gee.name, "Tom"); err != nil {
+		t.Fatalf("grpc invalidate failed: %v", err)
+	}
+	if _, ok := gee.mainCache.get("Tom"); ok || loads != 1 {
+		t.Fatal("Tom should be removed from the server cache")
+	}
+
+	// 服务端和客户端是同一个 group 所有 key 都转发给 server server 收到的请求不会再转发
+	server.Set(lis.Addr().String(), "other")
+	gee.RegisterPeers(client)
+	if v, err := gee.Get("Sam"); err != nil || v.String() != "Sam" || loads != 2 {
+		t.Fatalf("forwarded request should be loaded locally: %v %v", v, err)
+	}
+	if server.members.mismatches.Get() != 1 {
+		t.Fatal("server should detect the different peer list")
+	}
+}
+
+// 只有 geecache 节点返回的错误才保留原来的状态码 传输层和代理的错误视为 UNAVAILABLE
+func TestGRPCErrors(t *testing.T) {
+	NewGroup("grpc-errors", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+		}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	NewGRPCPool(lis.Addr().String(), nil).Register(s)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	// 不是 geecache 的服务 模拟返回 Internal 的代理
+	proxyLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := grpc.NewServer(grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		return status.Error(codes.Internal, "proxy error")
+	}))
+	go proxy.Serve(proxyLis)
+	defer proxy.Stop()
+
+	client := NewGRPCPool("client", &GRPCPoolOptions{Timeout: time.Second})
+	client.Set(lis.Addr().String(), proxyLis.Addr().String())
+	defer client.Close()
+	cases := []struct {
+		addr string
+		code pb.Code
+	}{
+		{lis.Addr().String(), pb.Code_NOT_FOUND},
+		{proxyLis.Addr().String(), pb.Code_UNAVAILABLE},
+	}
+	for _, c := range cases {
+		err := client.grpcGetters[c.addr].Get(&pb.Request{Group: "grpc-errors", Key: "Tom"}, &pb.Response{})
+		if codeOf(err) != c.code {
+			t.Fatalf("%s: expect %v, got %v", c.addr, c.code, err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package geecache
+
+import (
+	"Cache/geecache/consistenthash"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+远程节点的健康检查
+被动检查：httpGetter 每次请求没有收到响应（连接失败 超时等）记一次失败 收到任何响应清零
+主动检查：每隔 HealthCheckInterval 请求一次各节点的 basePath/_health
+连续失败 FailureThreshold 次的节点暂时移出哈希环 它负责的 key 由环上的下一个节点负责
+被移出的节点探测成功后按原来的权重重新加入哈希环
+移出只影响本节点的哈希环 其他节点可能仍然认为该节点在环上 此时 key 的归属暂时不一致
+节点状态可以通过 basePath/_peers 查看
+*/
+
+const (
+	healthPath              = "_health"
+	peersPath               = "_peers"
+	defaultFailureThreshold = 3
+	defaultPeerTimeout      = 10 * time.Second
+)
+
+// PeerStatus 是一个节点的健康状态 由 HTTPPool.Peers 和 _peers 接口返回
+type PeerStatus struct {
+	Peer      string `json:"peer"`
+	Self      bool   `json:"self"`
+	Healthy   bool   `json:"healthy"`             // false 表示已被移出哈希环
+	Failures  int    `json:"failures"`            // 连续失败的次数
+	InFlight  int    `json:"in_flight,omitempty"` // 正在处理的请求数 只在开启有界负载时统计
+	LastError string `json:"last_error,omitempty"`
+}
+
+// 每次请求使用的 ctx 超过 h.timeout 后取消
+// 没有响应的节点可能一直不断开连接 而 singleflight 中的加载只在所有调用方离开后才取消
+// 所以需要单独的超时 才能把这样的节点记为故障
+func (h *httpGetter) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if h.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, h.timeout)
+}
+
+// 把请求结果报告给 HTTPPool 远程节点返回的错误不算节点故障
+// ctx 是调用方的 context 调用方取消时（对冲请求输了或者调用方都离开了）结果不能说明节点的状态 不报告
+// 调用方还在等待时 h.timeout 到期算作节点故障
+func (h *httpGetter) reportResult(ctx context.Context, err error) {
+	if h.report == nil || ctx.Err() != nil {
+		return
+	}
+	var perr *PeerError
+	if errors.As(err, &perr) { // 收到了响应 节点本身是正常的
+		err = nil
+	}
+	h.report(err)
+}
+
+// 探测远程节点是否存活
+func (h *httpGetter) probe(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, h.baseURL+healthPath, nil)
+	if err != nil {
+		return err
+	}
+	res, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returnes: %v", res.Status)
+	}
+	return nil
+}
+
+// 记录一次请求或探测的结果 失败次数达到阈值时把节点移出哈希环 成功时重新加入
+func (p *HTTPPool) report(peer string, err error) {
+	p.mu.Lock()
+	h, ok := p.httpGetters[peer]
+	if !ok || p.peers == nil { // 节点已经被删除
+		p.mu.Unlock()
+		return
+	}
+	if err == nil {
+		h.failures, h.lastErr = 0, nil
+		if !h.ejected {
+			p.mu.Unlock()
+			return
+		}
+		old := p.cloneRingLocked()
+		h.ejected = false
+		if ring, ok := p.peers.(*consistenthash.Map); ok && h.weight > 1 {
+			ring.AddWeighted(peer, h.weight) // Add 会把权重重置为 1
+		} else {
+			p.peers.Add(peer)
+		}
+		p.reorderLocked()
+		p.Log("peer %s is healthy again, restored to the ring", peer)
+		p.unlockAndNotify(old)
+		return
+	}
+	h.failures++
+	h.lastErr = err
+	if h.ejected || p.opts.FailureThreshold < 0 || h.failures < p.opts.FailureThreshold {
+		p.mu.Unlock()
+		return
+	}
+	old := p.cloneRingLocked()
+	h.ejected = true
+	if ring, ok := p.peers.(*consistenthash.Map); ok {
+		h.weight = ring.Weight(peer)
+	}
+	p.peers.Remove(peer)
+	p.reorderLocked()
+	p.Log("peer %s failed %d times (%v), ejected from the ring", peer, h.failures, err)
+	p.unlockAndNotify(old)
+}
+
+// 定期探测所有远程节点 包括已被移出的节点
+func (p *HTTPPool) probeLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.probeAll(interval)
+		}
+	}
+}
+
+// 并发探测所有远程节点 每次探测最多等待 timeout
+func (p *HTTPPool) probeAll(timeout time.Duration) {
+	p.mu.Lock()
+	getters := make(map[string]*httpGetter, len(p.httpGetters))
+	for peer, getter := range p.httpGetters {
+		if peer != p.self {
+			getters[peer] = getter
+		}
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for peer, getter := range getters {
+		wg.Add(1)
+		go func(peer string, getter *httpGetter) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			p.report(peer, getter.probe(ctx))
+		}(peer, getter)
+	}
+	wg.Wait()
+}
+
+// Close 停止主动探测
+func (p *HTTPPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+}
+
+// Peers 返回所有节点的健康状态 按地址排序
+func (p *HTTPPool) Peers() []PeerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]PeerStatus, 0, len(p.httpGetters))
+	for peer, h := range p.httpGetters {
+		s := PeerStatus{Peer: peer, Self: peer == p.self, Healthy: !h.ejected, Failures: h.failures}
+		if ring, ok := p.peers.(*consistenthash.Map); ok {
+			s.InFlight = ring.Load(peer)
+		}
+		if h.lastErr != nil {
+			s.LastError = h.lastErr.Error()
+		}
+		peers = append(peers, s)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Peer < peers[j].Peer })
+	return peers
+}
+
+// 以 JSON 返回所有节点的健康状态
+func (p *HTTPPool) servePeers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.Peers())
+}
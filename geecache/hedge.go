@@ -0,0 +1,89 @@
+package geecache
+
+import (
+	"context"
+	"time"
+)
+
+/*
+对冲请求
+负责 key 的节点偶尔很慢时 p99 延迟由它决定
+主节点在 hedgeDelay 之内没有返回 就再向哈希环上的第二个节点（或本地回调函数）发一次请求
+哪个先成功用哪个 另一个请求随 ctx 一起取消
+*/
+
+// 一次对冲中某个请求的结果 hedge 表示来自对冲请求 local 表示由本地回调函数加载
+type hedgeResult struct {
+	value ByteView
+	err   error
+	hedge bool
+	local bool
+}
+
+// 从 peer 获取 key 开启对冲时 超过 hedgeDelay 后再向备用节点发一次请求
+// local 为 true 表示返回的结果来自本地加载 调用方不必再回退到本地加载
+func (g *Group) getFromPeerHedged(ctx context.Context, peer PeerGetter, key string) (value ByteView, local bool, err error) {
+	if g.hedgeDelay <= 0 {
+		value, err = g.getFromPeer(ctx, peer, key)
+		return value, false, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // 返回后取消还没有结束的请求
+
+	results := make(chan hedgeResult, 2)
+	go func() {
+		value, err := g.getFromPeer(ctx, peer, key)
+		results <- hedgeResult{value: value, err: err}
+	}()
+	timer := time.NewTimer(g.hedgeDelay)
+	defer timer.Stop()
+
+	hedged, pending := false, 1
+	var first hedgeResult // 都失败时返回的结果
+	for {
+		select {
+		case <-timer.C:
+			hedged = true
+			pending++
+			g.stats.Hedges.Add(1)
+			go func() { results <- g.hedge(ctx, key, peer) }()
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if r.hedge {
+					g.stats.HedgeWins.Add(1) // 对冲请求先于主节点返回
+				}
+				return r.value, r.local, nil
+			}
+			if !hedged { // 主节点在对冲之前就失败了 由 load 决定是否回退
+				return ByteView{}, false, r.err
+			}
+			if first.err == nil || r.local { // 本地加载过了 不必再回退
+				first = r
+			}
+			if pending == 0 {
+				return ByteView{}, first.local, first.err
+			}
+		}
+	}
+}
+
+// 向哈希环上负责 key 的除 primary 以外的第一个节点发送对冲请求 该节点是自己或者没有时本地加载
+// 有界负载时 primary 可能不是负责 key 的第一个节点 对冲请求要跳过它 不能再发给同一个节点
+// getFromPeer 发出的请求 Hops 为 1 该节点直接从本地加载 不会再转发回慢的主节点
+func (g *Group) hedge(ctx context.Context, key string, primary PeerGetter) hedgeResult {
+	if picker, ok := g.peers.(PeersPicker); ok {
+		for _, peer := range picker.PickPeers(key, 2) {
+			if peer == primary {
+				continue
+			}
+			if peer != nil {
+				value, err := g.getFromPeer(ctx, peer, key)
+				return hedgeResult{value: value, err: err, hedge: true}
+			}
+			break
+		}
+	}
+	value, err := g.getLoacally(ctx, key)
+	return hedgeResult{value: value, err: err, hedge: true, local: true}
+}
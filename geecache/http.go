@@ -3,14 +3,20 @@ package geecache
 import (
 	"Cache/geecache/consistenthash"
 	pb "Cache/geecache/geecachepb"
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/golang/protobuf/proto"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 /*
@@ -22,23 +28,106 @@ https://golang.org/pkg/net/http/
 const (
 	defaultBasePath = "/_geecache/"
 	defaultReplicas = 50
+	// basePath 下以 _ 开头的路径留给节点间的管理接口 不会被当作 group 名
+	invalidatePath = "_invalidate"
+	statsPath      = "_stats"
+	batchPath      = "_batch"
+	// 响应带有该 header 时表示 key 在数据源中不存在 而不是 group 不存在或代理返回的其他 404
+	notFoundHeader = "X-Geecache-Not-Found"
 )
 
+// HTTPPoolOptions 是 HTTPPool 的可选配置 零值字段使用默认值
+type HTTPPoolOptions struct {
+	// 节点间通讯地址的前缀 默认为 defaultBasePath 所有节点必须一致
+	BasePath string
+	// 一致性哈希的虚拟节点倍数 默认为 defaultReplicas 所有节点必须一致
+	Replicas int
+	// 一致性哈希使用的 Hash 函数 默认为 crc32.ChecksumIEEE 所有节点必须一致
+	HashFn consistenthash.Hash
+	// 创建选择节点的算法 例如 consistenthash.NewMaglev 所有节点必须一致
+	// 默认为由 Replicas 和 HashFn 构成的哈希环 consistenthash.Map
+	// consistenthash.Jump 与节点加入的顺序有关 HTTPPool 每次节点变化后按地址排序重建
+	Placement func() consistenthash.Placement
+	// 请求远程节点使用的 Client 可以设置超时 默认为 http.DefaultClient
+	Client *http.Client
+	// Client 为 nil 时 使用 Transport 创建 Client 用于调整连接池等参数
+	Transport http.RoundTripper
+	// 主动探测远程节点 basePath/_health 的间隔 <=0 表示不主动探测
+	HealthCheckInterval time.Duration
+	// 连续失败多少次后把节点暂时移出哈希环 默认为 defaultFailureThreshold <0 表示从不移出
+	FailureThreshold int
+	// 每次 Get 和批量请求最多等待远程节点的时间 超时算作一次节点故障
+	// 默认为 defaultPeerTimeout <0 表示不限制 此时没有响应的节点只能靠 Client 的超时或主动探测发现
+	PeerTimeout time.Duration
+	// >0 时开启有界负载 每个远程节点正在处理的请求数最多为平均值的 1+LoadBound 倍
+	// 超出后 key 交给哈希环上的下一个节点 只对默认的哈希环有效 不能与 ReplicationFactor 同时开启
+	LoadBound float64
+	// 每个 key 存放在前 ReplicationFactor 个不同的节点上 加载时依次尝试 所有节点必须一致
+	// 主节点加载后异步推送给其他副本 <=1 表示不复制
+	// 副本按哈希环上的顺序固定 不能与 LoadBound 同时开启 否则 NewHTTPPoolOpts 会 panic
+	ReplicationFactor int
+}
+
 type HTTPPool struct {
 	self     string //  用来记录自己的地址 包括主机名 IP 和端口
 	basePath string // 节点间通讯地址的前缀 默认为上面的
+	opts     HTTPPoolOptions
 	/* 添加节点选择功能 */
-	mu          sync.Mutex             // 保护 peers 和 httpGetters
-	peers       *consistenthash.Map    // 一致性哈希算法的Map 根据 key 选择节点
-	httpGetters map[string]*httpGetter // keyed by e.g. "http://10.0.0.2:8008"
+	mu          sync.Mutex               // 保护 peers 和 httpGetters
+	peers       consistenthash.Placement // 一致性哈希算法的Map 根据 key 选择节点
+	httpGetters map[string]*httpGetter   // keyed by e.g. "http://10.0.0.2:8008"
 	// 映射远程节点对应的 httpGetter 每个远程节点对应一个 httpGetter 因为 httpGetter 与远程节点的地址 baseURL 有关
+	observers []func(moved []consistenthash.Range) // 节点变更后接收归属发生变化的区间
+	changes   [][]consistenthash.Range             // 还没有通知给观察者的变更 按发生的顺序排列
+	notifying bool                                 // 是否已有调用在通知观察者
+
+	serverRequests AtomicInt // 统计信息 见 PoolStats
+	serverErrors   AtomicInt
+	invalidations  AtomicInt
+	pushes         AtomicInt
+	members        membership   // 节点列表的版本 随请求发送给远程节点
+	removed        removalHooks // 节点被删除后通知 见 OnPeerRemoved
+
+	stop chan struct{} // 关闭后停止主动探测 见 Close
 }
 
 func NewHTTPPool(self string) *HTTPPool {
-	return &HTTPPool{
-		self:     self,
-		basePath: defaultBasePath,
+	return NewHTTPPoolOpts(self, nil)
+}
+
+// NewHTTPPoolOpts 使用 o 创建 HTTPPool o 为 nil 时与 NewHTTPPool 相同
+func NewHTTPPoolOpts(self string, o *HTTPPoolOptions) *HTTPPool {
+	p := &HTTPPool{self: self}
+	if o != nil {
+		p.opts = *o
+	}
+	if p.opts.BasePath == "" {
+		p.opts.BasePath = defaultBasePath
+	}
+	if p.opts.Replicas == 0 {
+		p.opts.Replicas = defaultReplicas
+	}
+	if p.opts.Client == nil {
+		p.opts.Client = http.DefaultClient
+		if p.opts.Transport != nil {
+			p.opts.Client = &http.Client{Transport: p.opts.Transport}
+		}
+	}
+	if p.opts.FailureThreshold == 0 {
+		p.opts.FailureThreshold = defaultFailureThreshold
+	}
+	if p.opts.PeerTimeout == 0 {
+		p.opts.PeerTimeout = defaultPeerTimeout
+	}
+	if p.opts.LoadBound > 0 && p.opts.ReplicationFactor > 1 {
+		panic("geecache: LoadBound cannot be combined with ReplicationFactor")
+	}
+	p.basePath = p.opts.BasePath
+	p.stop = make(chan struct{})
+	if p.opts.HealthCheckInterval > 0 {
+		go p.probeLoop(p.opts.HealthCheckInterval)
 	}
+	return p
 }
 
 // 最为核心的ServeHTTP 方法
@@ -51,10 +140,34 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		panic("HTTPPool serving unexpected path:" + r.URL.Path)
 	}
 	p.Log("%s %s", r.Method, r.URL.Path) // 方法 + url
+	switch r.URL.Path[len(p.basePath):] {
+	case invalidatePath:
+		p.serveInvalidate(w, r)
+		return
+	case statsPath:
+		p.serveStats(w, r)
+		return
+	case batchPath:
+		p.serveBatch(w, r)
+		return
+	case healthPath:
+		w.Write([]byte("ok"))
+		return
+	case peersPath:
+		p.servePeers(w, r)
+		return
+	case ringPath:
+		p.serveRing(w, r)
+		return
+	case pushPath:
+		p.servePush(w, r)
+		return
+	}
+	p.serverRequests.Add(1)
 	// /<basepath>/<groupname>/<key> required
 	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2)
 	if len(parts) != 2 {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		p.writeResponse(w, &pb.Response{Code: pb.Code_INVALID_ARGUMENT, Message: "bad request"})
 		return
 	}
 
@@ -62,73 +175,505 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	key := parts[1]
 	group := GetGroup(groupName) // 通过 groupName 获得group实例
 	if group == nil {
-		http.Error(w, "no such group:"+groupName, http.StatusNotFound)
+		// 请求方可以改为本地加载 所以是 UNAVAILABLE 而不是 NOT_FOUND
+		p.writeResponse(w, &pb.Response{Code: pb.Code_UNAVAILABLE, Message: "no such group:" + groupName})
+		return
+	}
+	view, err := group.GetContext(p.requestContext(r, 0), key) // 获取缓存数据 请求方断开后不再继续加载
+	if err != nil {
+		p.writeResponse(w, &pb.Response{Code: codeOf(err), Message: err.Error()})
 		return
 	}
-	view, err := group.Get(key) // 获取缓存数据
-	// proto新增
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()})
+	p.writeResponse(w, &pb.Response{Value: view.ByteSlice(), Expire: view.expireUnixNano()}) // proto 新增
+}
 
+// 以 proto 编码写出响应 HTTP 状态码由 res.Code 决定 出错时响应体同样是 pb.Response
+func (p *HTTPPool) writeResponse(w http.ResponseWriter, res *pb.Response) {
+	body, err := proto.Marshal(res)
 	if err != nil {
+		p.serverErrors.Add(1)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if res.Code != pb.Code_OK && res.Code != pb.Code_NOT_FOUND { // 不存在是正常的查询结果
+		p.serverErrors.Add(1)
+	}
 	w.Header().Set("Content-Type", "application/octet-stream")
+	if res.Code == pb.Code_NOT_FOUND {
+		w.Header().Set(notFoundHeader, "1")
+	}
+	w.WriteHeader(httpStatus(res.Code))
 	//w.Write(view.ByteSlice())
-	w.Write(body) // proto 新增
+	w.Write(body)
+}
+
+// 处理其他节点广播过来的删除请求 只删除本地缓存 不再继续广播
+func (p *HTTPPool) serveInvalidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &pb.InvalidateRequest{}
+	if err = proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	group := GetGroup(req.GetGroup())
+	if group == nil {
+		http.Error(w, "no such group:"+req.GetGroup(), http.StatusNotFound)
+		return
+	}
+	p.invalidations.Add(1)
+	group.removeLocally(req.GetKey())
+	w.WriteHeader(http.StatusOK)
+}
+
+// 处理批量查询 请求体为 proto 编码的 BatchRequest 响应体为 BatchResponse
+// 单个 key 的错误放在对应的 Response.Code 中 HTTP 状态码只反映整个请求
+func (p *HTTPPool) serveBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p.serverRequests.Add(1)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		p.writeResponse(w, &pb.Response{Code: pb.Code_INVALID_ARGUMENT, Message: err.Error()})
+		return
+	}
+	req := &pb.BatchRequest{}
+	if err = proto.Unmarshal(body, req); err != nil {
+		p.writeResponse(w, &pb.Response{Code: pb.Code_INVALID_ARGUMENT, Message: err.Error()})
+		return
+	}
+	group := GetGroup(req.GetGroup())
+	if group == nil {
+		p.writeResponse(w, &pb.Response{Code: pb.Code_UNAVAILABLE, Message: "no such group:" + req.GetGroup()})
+		return
+	}
+	res, failed := serveBatch(p.requestContext(r, req.GetHops()), group, req.GetKeys())
+	p.serverErrors.Add(int64(failed))
+	if body, err = proto.Marshal(res); err != nil {
+		p.writeResponse(w, &pb.Response{Code: pb.Code_INTERNAL, Message: err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+// Stats 返回 HTTPPool 作为服务端的统计信息快照
+func (p *HTTPPool) Stats() PoolStats {
+	return PoolStats{
+		ServerRequests: p.serverRequests.Get(),
+		ServerErrors:   p.serverErrors.Get(),
+		Invalidations:  p.invalidations.Get(),
+		RingMismatches: p.members.mismatches.Get(),
+		Pushes:         p.pushes.Get(),
+	}
+}
+
+// 以 JSON 返回 HTTPPool 和所有 group 的统计信息
+func (p *HTTPPool) serveStats(w http.ResponseWriter, r *http.Request) {
+	res := struct {
+		Pool   PoolStats        `json:"pool"`
+		Groups map[string]Stats `json:"groups"`
+	}{
+		Pool:   p.Stats(),
+		Groups: make(map[string]Stats),
+	}
+	for name, g := range allGroups() {
+		res.Groups[name] = g.Stats()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
 }
 
 /* 上面是服务端 */
 
 /* 下面实现客户端 */
 type httpGetter struct {
-	baseURL string // 表示要访问的远程节点的地址 如http://example.com/_geecache/
+	baseURL string        // 表示要访问的远程节点的地址 如http://example.com/_geecache/
+	client  *http.Client  // 发送请求的 Client 由 HTTPPoolOptions 决定
+	latency *histogram    // Get 请求的耗时分布 由 /metrics 输出
+	report  func(error)   // 把请求结果报告给 HTTPPool 做被动健康检查 可为 nil
+	timeout time.Duration // 每次请求最多等待的时间 <=0 表示不限制
+	track   func(int)     // 把正在处理的批量请求数的变化报告给 HTTPPool 用于有界负载 可为 nil
+	version func() string // 返回本节点的节点列表版本 随请求发送 可为 nil
+
+	// 健康状态 由 HTTPPool.mu 保护
+	failures int   // 连续失败的次数
+	lastErr  error // 最近一次失败的原因
+	ejected  bool  // 是否已被移出哈希环
+	weight   int   // 被移出前在哈希环上的权重 重新加入时恢复
+}
+
+func newHTTPGetter(baseURL string) *httpGetter {
+	return &httpGetter{
+		baseURL: baseURL,
+		client:  http.DefaultClient,
+		latency: newHistogram(defaultLatencyBuckets),
+	}
 }
 
 // 获取返回值，并转化为[]bytes 类型
 //func (h *httpGetter) Get(group string, key string) ([]byte, error) {
 func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
+	return h.GetContext(context.Background(), in, out)
+}
+
+// GetContext 与 Get 相同 parent 取消或超时后请求被中断 每次请求最多等待 HTTPPoolOptions.PeerTimeout
+// 有界负载下 Get 请求的负载由 HTTPPool.PickPeerLoad 在选择节点时计入 这里不再统计
+func (h *httpGetter) GetContext(parent context.Context, in *pb.Request, out *pb.Response) (err error) {
+	defer func() { h.reportResult(parent, err) }()
+	ctx, cancel := h.withTimeout(parent)
+	defer cancel()
 	u := fmt.Sprintf(
 		"%v%v/%v",
 		h.baseURL,
 		url.QueryEscape(in.GetGroup()),
 		url.QueryEscape(in.GetKey()))
 	// fmt.Println("u", u)
-	res, err := http.Get(u)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	h.setForwardHeaders(req, in.GetHops())
+	start := time.Now()
+	defer func() { h.latency.observe(time.Since(start)) }() // 包括读取响应体的时间
+	res, err := h.client.Do(req.WithContext(ctx))
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 
+	bytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("reading response body: %v", err)
+	}
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returnes: %v", res.Status) // 没法访问到 返回错误
+		return h.responseError(res, bytes)
 	}
+	if err = proto.Unmarshal(bytes, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	if out.Code != pb.Code_OK {
+		return &PeerError{Peer: h.baseURL, Code: out.Code, Message: out.Message}
+	}
+	return nil
+}
 
-	bytes, err := ioutil.ReadAll(res.Body)
-	err = proto.Unmarshal(bytes, out)
+// GetMulti 一次请求获取多个 key 请求体为 proto 编码的 BatchRequest
+// 整个请求失败时 响应体是 pb.Response 与 GetContext 一样还原为 *PeerError
+func (h *httpGetter) GetMulti(parent context.Context, in *pb.BatchRequest, out *pb.BatchResponse) (err error) {
+	defer h.inflight()()
+	defer func() { h.reportResult(parent, err) }()
+	ctx, cancel := h.withTimeout(parent)
+	defer cancel()
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, h.baseURL+batchPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	h.setForwardHeaders(req, in.GetHops())
+	start := time.Now()
+	defer func() { h.latency.observe(time.Since(start)) }()
+	res, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err = ioutil.ReadAll(res.Body)
 	if err != nil {
 		return fmt.Errorf("reading response body: %v", err)
 	}
+	if res.StatusCode != http.StatusOK {
+		return h.responseError(res, body)
+	}
+	if err = proto.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	return nil
+}
+
+// 还原非 200 响应表示的错误
+// 响应体是 geecache 编码的 pb.Response 且与状态码一致时使用其中的 code
+// 否则（如代理返回的错误页）只能根据状态码推断 带有 notFoundHeader 的 404 表示 key 不存在
+func (h *httpGetter) responseError(res *http.Response, body []byte) error {
+	failed := &pb.Response{}
+	if err := proto.Unmarshal(body, failed); err == nil && failed.Code != pb.Code_OK && httpStatus(failed.Code) == res.StatusCode {
+		return &PeerError{Peer: h.baseURL, Code: failed.Code, Message: failed.Message}
+	}
+	code := codeFromHTTPStatus(res.StatusCode)
+	if res.StatusCode == http.StatusNotFound && res.Header.Get(notFoundHeader) != "" {
+		code = pb.Code_NOT_FOUND // 远程节点确认 key 不存在
+	}
+	return &PeerError{Peer: h.baseURL, Code: code, Message: res.Status}
+}
+
+// 通知远程节点删除缓存 请求体为 proto 编码的 InvalidateRequest
+func (h *httpGetter) invalidate(in *pb.InvalidateRequest) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	res, err := h.client.Post(h.baseURL+invalidatePath, "application/octet-stream", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returnes: %v", res.Status)
+	}
 	return nil
 }
 
 var _ PeerGetter = (*httpGetter)(nil) // 为了用来确保 htppGetter 实现了 PeerGetter接口
+var _ PeerGetterContext = (*httpGetter)(nil)
+var _ BatchPeerGetter = (*httpGetter)(nil)
+var _ PeersPicker = (*HTTPPool)(nil)
+var _ PeerRemovalNotifier = (*HTTPPool)(nil)
 
 /* 实现 PeerPicker 接口 */
 // Set 方法 实例化了一致性哈希算法，并添加了传入的节点
+// 会替换掉原有的全部节点 仍然存在的节点复用原来的 httpGetter
 func (p *HTTPPool) Set(peers ...string) {
 	// fmt.Println(peers)  // [http://localhost:8001 http://localhost:8002 http://localhost:8003]
 	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.peers = consistenthash.New(defaultReplicas, nil)
-	p.peers.Add(peers...)
-	p.httpGetters = make(map[string]*httpGetter, len(peers))
+	old := p.peers
+	p.peers = p.newRing()
+	getters := make(map[string]*httpGetter, len(peers))
 	for _, peer := range peers {
-		p.httpGetters[peer] = &httpGetter{baseURL: peer + p.basePath}
+		if getter, ok := p.httpGetters[peer]; ok {
+			getters[peer] = getter
+			if !getter.ejected { // 被移出的节点恢复健康后才重新加入
+				p.peers.Add(peer)
+			}
+			continue
+		}
+		p.peers.Add(peer)
+		getters[peer] = p.newGetter(peer)
 		//fmt.Println("测试",peer) // 测试 http://localhost:8001
 		//fmt.Println(*p.httpGetters[peer]) // {http://localhost:8001/_geecache/}
 	}
+	var removed []PeerGetter
+	for peer, getter := range p.httpGetters {
+		if _, ok := getters[peer]; !ok {
+			removed = append(removed, getter)
+		}
+	}
+	p.httpGetters = getters
+	p.reorderLocked()
+	p.members.set(p.peerListLocked())
+	p.unlockAndNotify(old)
+	p.removed.notify(removed)
+}
 
+// AddPeers 在现有节点的基础上增加节点 只有新节点接管的 key 会移动
+func (p *HTTPPool) AddPeers(peers ...string) {
+	p.mu.Lock()
+	old := p.cloneRingLocked()
+	if p.peers == nil {
+		p.peers = p.newRing()
+	}
+	if p.httpGetters == nil {
+		p.httpGetters = make(map[string]*httpGetter, len(peers))
+	}
+	for _, peer := range peers {
+		if _, ok := p.httpGetters[peer]; ok {
+			continue // 已经存在的节点不重复添加
+		}
+		p.peers.Add(peer)
+		p.httpGetters[peer] = p.newGetter(peer)
+	}
+	p.reorderLocked()
+	p.members.set(p.peerListLocked())
+	p.unlockAndNotify(old)
+}
+
+// RemovePeers 删除节点 只有被删除节点负责的 key 会移动到环上的下一个节点
+func (p *HTTPPool) RemovePeers(peers ...string) {
+	p.mu.Lock()
+	old := p.cloneRingLocked()
+	var removed []PeerGetter
+	for _, peer := range peers {
+		if p.peers != nil {
+			p.peers.Remove(peer)
+		}
+		if getter, ok := p.httpGetters[peer]; ok {
+			removed = append(removed, getter)
+			delete(p.httpGetters, peer)
+		}
+	}
+	p.reorderLocked()
+	p.members.set(p.peerListLocked())
+	p.unlockAndNotify(old)
+	p.removed.notify(removed)
+}
+
+// OnPeerRemoved 注册一个回调 Set 或 RemovePeers 删除节点后调用 实现 PeerRemovalNotifier
+func (p *HTTPPool) OnPeerRemoved(fn func(peer PeerGetter)) {
+	p.removed.add(fn)
+}
+
+// OnPeersChanged 注册一个观察者 每次 Set AddPeers RemovePeers 之后
+// 收到归属发生变化的哈希区间 可用于预热或迁移这些区间内的数据
+// 只有使用默认的哈希环时才能按区间比较 使用其他 Placement 时观察者不会被调用
+func (p *HTTPPool) OnPeersChanged(fn func(moved []consistenthash.Range)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.observers = append(p.observers, fn)
+}
+
+// 返回所有节点的地址 包括被移出哈希环的节点 用于计算节点列表的版本
+func (p *HTTPPool) peerListLocked() []string {
+	peers := make([]string, 0, len(p.httpGetters))
+	for peer := range p.httpGetters {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// 没有观察者时不需要比较 也就不用拷贝环
+func (p *HTTPPool) cloneRingLocked() consistenthash.Placement {
+	ring, ok := p.peers.(*consistenthash.Map)
+	if len(p.observers) == 0 || !ok {
+		return p.peers
+	}
+	return ring.Clone()
+}
+
+// 按 opts 创建空的哈希环
+func (p *HTTPPool) newRing() consistenthash.Placement {
+	if p.opts.Placement != nil {
+		return p.opts.Placement()
+	}
+	ring := consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+	ring.SetLoadBound(p.opts.LoadBound)
+	return ring
+}
+
+// Jump 按节点加入的顺序给节点编号 Set 传入的顺序不同 或者节点被移出后重新加入末尾 都会改变 key 的归属
+// 使用 Jump 时按地址排序重新添加哈希环上的节点 节点相同的服务器结果相同 恢复的节点也回到原来的位置
+func (p *HTTPPool) reorderLocked() {
+	if _, ok := p.peers.(*consistenthash.Jump); !ok {
+		return
+	}
+	peers := make([]string, 0, len(p.httpGetters))
+	for peer, getter := range p.httpGetters {
+		if !getter.ejected {
+			peers = append(peers, peer)
+		}
+	}
+	sort.Strings(peers)
+	p.peers = p.newRing()
+	p.peers.Add(peers...)
+}
+
+// 创建访问 peer 的 httpGetter
+func (p *HTTPPool) newGetter(peer string) *httpGetter {
+	h := newHTTPGetter(peer + p.basePath)
+	h.client = p.opts.Client
+	h.version = p.members.get
+	h.timeout = p.opts.PeerTimeout
+	if peer != p.self {
+		h.report = func(err error) { p.report(peer, err) }
+		if p.opts.LoadBound > 0 {
+			h.track = func(delta int) { p.track(peer, delta) }
+		}
+	}
+	return h
+}
+
+// 记录 peer 正在处理的请求数 只有本节点发出的请求会被统计
+// Get 请求由 PickPeerLoad 在选择节点时计入 批量请求在发送时计入
+func (p *HTTPPool) track(peer string, delta int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ring, ok := p.peers.(*consistenthash.Map)
+	if !ok {
+		return
+	}
+	if delta > 0 {
+		ring.Inc(peer)
+	} else {
+		ring.Done(peer)
+	}
+}
+
+// 把转发次数和本节点的节点列表版本放入请求头
+func (h *httpGetter) setForwardHeaders(req *http.Request, hops uint32) {
+	if hops > 0 {
+		req.Header.Set(hopsHeader, strconv.Itoa(int(hops)))
+	}
+	if h.version != nil {
+		if v := h.version(); v != "" {
+			req.Header.Set(ringVersionHeader, v)
+		}
+	}
+}
+
+// 检查请求头中的节点列表版本 返回处理请求使用的 ctx
+// 已经被其他节点转发过的请求只从本地加载
+func (p *HTTPPool) requestContext(r *http.Request, hops uint32) context.Context {
+	p.members.check(r.Header.Get(ringVersionHeader), r.RemoteAddr, p.Log)
+	if n, _ := strconv.Atoi(r.Header.Get(hopsHeader)); n > 0 || hops > 0 {
+		return withForwarded(r.Context())
+	}
+	return r.Context()
+}
+
+// 开始一个请求 返回的函数在请求结束时调用
+func (h *httpGetter) inflight() func() {
+	if h.track == nil {
+		return func() {}
+	}
+	h.track(1)
+	return func() { h.track(-1) }
+}
+
+// 计算 old 与当前环的差异并释放 p.mu 然后通知观察者 调用前持有 p.mu
+// 观察者在锁外执行 可以在回调中调用 PickPeer 或者修改节点
+// 变更先放入 p.changes 由同一时间唯一的通知者按发生的顺序发出
+// 已经有其他调用在通知时 本次变更交给它发出 直接返回
+func (p *HTTPPool) unlockAndNotify(old consistenthash.Placement) {
+	ring, ok := p.peers.(*consistenthash.Map)
+	if len(p.observers) == 0 || !ok {
+		p.mu.Unlock()
+		return
+	}
+	before, ok := old.(*consistenthash.Map)
+	if !ok { // 之前还没有设置节点
+		before = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+	}
+	p.changes = append(p.changes, before.Diff(ring))
+	if p.notifying {
+		p.mu.Unlock()
+		return
+	}
+	p.notifying = true
+	for len(p.changes) > 0 {
+		moved := p.changes[0]
+		p.changes = p.changes[1:]
+		observers := p.observers
+		p.mu.Unlock()
+		for _, fn := range observers {
+			fn(moved)
+		}
+		p.mu.Lock()
+	}
+	p.notifying = false
+	p.mu.Unlock()
 }
 
 //  PickPeer picks a peer according to key
@@ -136,8 +681,8 @@ func (p *HTTPPool) Set(peers ...string) {
 func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	// fmt.Println(key, "对应的peer为", p.peers.Get(key))
-	if peer := p.peers.Get(key); peer != "" && peer != p.self { // 判断 peer 不能为空 且不能为自己
+	peer := p.pickLocked(key)
+	if peer != "" && peer != p.self { // 判断 peer 不能为空 且不能为自己
 		p.Log("Pick peer %s", peer)
 		return p.httpGetters[peer], true
 	}
@@ -145,4 +690,68 @@ func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 	return nil, false
 }
 
+// PickPeerLoad 与 PickPeer 相同 开启有界负载时在同一次加锁中把请求计入选出的节点
+// 之后的选择马上能看到这个请求 请求结束后调用 release 实现 LoadPicker
+func (p *HTTPPool) PickPeerLoad(key string) (PeerGetter, func(), bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peer := p.pickLocked(key)
+	if peer == "" || peer == p.self {
+		return nil, func() {}, false
+	}
+	p.Log("Pick peer %s", peer)
+	ring, ok := p.peers.(*consistenthash.Map)
+	if !ok || p.opts.LoadBound <= 0 {
+		return p.httpGetters[peer], func() {}, true
+	}
+	ring.Inc(peer)
+	var once sync.Once
+	return p.httpGetters[peer], func() { once.Do(func() { p.track(peer, -1) }) }, true
+}
+
+// 返回负责 key 的节点 开启有界负载时跳过已满的节点 调用前持有 p.mu
+func (p *HTTPPool) pickLocked(key string) string {
+	if p.peers == nil { // 还没有设置节点
+		return ""
+	}
+	// fmt.Println(key, "对应的peer为", p.peers.Get(key))
+	if ring, ok := p.peers.(*consistenthash.Map); ok && p.opts.LoadBound > 0 {
+		return ring.GetLeast(key) // 负责 key 的节点已满时选择下一个节点
+	}
+	return p.peers.Get(key)
+}
+
+// PickPeers 返回负责 key 的前 n 个节点对应的 HTTP 客户端 自己对应的元素为 nil
+func (p *HTTPPool) PickPeers(key string, n int) []PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil
+	}
+	nodes := p.peers.GetN(key, n)
+	getters := make([]PeerGetter, len(nodes))
+	for i, node := range nodes {
+		if getter, ok := p.httpGetters[node]; ok && node != p.self {
+			getters[i] = getter
+		}
+	}
+	return getters
+}
+
+// Invalidate 把删除广播给除自己以外的所有节点 每个节点失败后最多重试 invalidateRetries 次
+// 所有节点都确认时返回 nil 否则返回 *InvalidateError 列出没有确认的节点
+func (p *HTTPPool) Invalidate(group string, key string) error {
+	p.mu.Lock()
+	peers := make(map[string]invalidator, len(p.httpGetters))
+	for peer, getter := range p.httpGetters {
+		if peer != p.self {
+			peers[peer] = getter
+		}
+	}
+	p.mu.Unlock()
+	return broadcastInvalidate(peers, &pb.InvalidateRequest{Group: group, Key: key}, p.Log)
+}
+
 var _ PeerPicker = (*HTTPPool)(nil) // 验证  HTTPPool 是否实现了PeerPicker 接口
+var _ PeerInvalidator = (*HTTPPool)(nil)
+var _ LoadPicker = (*HTTPPool)(nil)
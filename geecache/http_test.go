@@ -0,0 +1,753 @@
+package geecache
+
+import (
+	"Cache/geecache/consistenthash"
+	pb "Cache/geecache/geecachepb"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 启动一个使用 HTTPPool 的测试节点 调用方负责关闭 srv
+// 注意同一进程内的节点共享全局的 groups
+func newTestPeer() (*HTTPPool, *httptest.Server) {
+	pool := NewHTTPPool("")
+	srv := httptest.NewServer(pool)
+	pool.self = srv.URL
+	return pool, srv
+}
+
+func TestInvalidate(t *testing.T) {
+	loads := 0
+	gee := NewGroup("invalidate", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(key), nil
+		}))
+	a, srvA := newTestPeer()
+	defer srvA.Close()
+	_, srvB := newTestPeer()
+	defer srvB.Close()
+	dead := httptest.NewServer(nil)
+	dead.Close() // 已关闭的节点 无法确认删除
+
+	// 同一个进程内 b 和 a 共用 gee a 只广播给其他节点 所以 key 只可能是被 b 删除的
+	gee.Get("Tom")
+	a.Set(srvA.URL, srvB.URL)
+	if err := a.Invalidate(gee.name, "Tom"); err != nil {
+		t.Fatalf("invalidate failed: %v", err)
+	}
+	if _, ok := gee.mainCache.get("Tom"); ok {
+		t.Fatal("Tom should be removed from the peer's cache")
+	}
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "Tom" || loads != 2 {
+		t.Fatalf("Tom should be reloaded after invalidation, got %s with %d loads", view, loads)
+	}
+
+	a.Set(srvA.URL, srvB.URL, dead.URL)
+	err := a.Invalidate(gee.name, "Tom")
+	ierr, ok := err.(*InvalidateError)
+	if !ok || len(ierr.Failed) != 1 || ierr.Failed[dead.URL] == nil {
+		t.Fatalf("expect %s to fail acknowledging, got %v", dead.URL, err)
+	}
+}
+
+func TestAddRemovePeers(t *testing.T) {
+	pool := NewHTTPPool("http://a")
+	var moved []consistenthash.Range
+	pool.OnPeersChanged(func(m []consistenthash.Range) {
+		moved = m
+	})
+
+	pool.AddPeers("http://a", "http://b")
+	getter := pool.httpGetters["http://b"]
+	if len(moved) == 0 {
+		t.Fatal("adding peers should move key ranges")
+	}
+	for _, r := range moved {
+		if r.From != "" {
+			t.Fatalf("keys should move from an empty ring, got %v", r)
+		}
+	}
+
+	pool.AddPeers("http://c")
+	if pool.httpGetters["http://b"] != getter {
+		t.Fatal("existing httpGetter should be reused")
+	}
+	for _, r := range moved {
+		if r.To != "http://c" {
+			t.Fatalf("only keys taken over by c should move, got %v", r)
+		}
+	}
+
+	pool.RemovePeers("http://c")
+	for _, r := range moved {
+		if r.From != "http://c" {
+			t.Fatalf("only keys owned by c should move, got %v", r)
+		}
+	}
+	if nodes := pool.peers.Nodes(); len(nodes) != 2 || pool.httpGetters["http://c"] != nil {
+		t.Fatalf("c should be removed, nodes %v", nodes)
+	}
+}
+
+// 在回调中修改节点 所有观察者仍然按发生的顺序收到变更
+func TestPeersChangedOrder(t *testing.T) {
+	pool := NewHTTPPool("http://a")
+	var first, second []string
+	pool.OnPeersChanged(func(m []consistenthash.Range) {
+		first = append(first, m[0].To)
+		if m[0].To == "http://b" {
+			pool.AddPeers("http://c")
+		}
+	})
+	pool.OnPeersChanged(func(m []consistenthash.Range) {
+		second = append(second, m[0].To)
+	})
+	pool.AddPeers("http://b")
+	want := []string{"http://b", "http://c"}
+	if !reflect.DeepEqual(first, want) || !reflect.DeepEqual(second, want) {
+		t.Fatalf("changes delivered out of order: %v %v", first, second)
+	}
+}
+
+// 节点被删除后 Group 不再保留它的熔断器
+func TestBreakerPeerRemoved(t *testing.T) {
+	pool := NewHTTPPool("http://a")
+	pool.Set("http://a", "http://b", "http://c")
+	gee := NewGroup("breaker-removed", 2<<10, GetterFunc(func(key string) ([]byte, error) { return []byte(key), nil }), WithCircuitBreaker(1, time.Second))
+	gee.RegisterPeers(pool)
+	b, c := pool.httpGetters["http://b"], pool.httpGetters["http://c"]
+	gee.breaker(b)
+	gee.breaker(c)
+
+	pool.RemovePeers("http://b")
+	if _, ok := gee.breakers.Load(b); ok {
+		t.Fatal("breaker of b should be removed")
+	}
+	pool.Set("http://a", "http://d")
+	if _, ok := gee.breakers.Load(c); ok {
+		t.Fatal("breaker of c should be removed")
+	}
+}
+
+func TestServeStats(t *testing.T) {
+	gee := NewGroup("served", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	pool, srv := newTestPeer()
+	defer srv.Close()
+
+	http.Get(srv.URL + defaultBasePath + "served/Tom")
+	http.Get(srv.URL + defaultBasePath + "served/Tom")
+	res, err := http.Get(srv.URL + defaultBasePath + statsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var stats struct {
+		Pool   PoolStats
+		Groups map[string]Stats
+	}
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pool != pool.Stats() || stats.Pool.ServerRequests != 2 {
+		t.Fatalf("unexpected pool stats %+v", stats.Pool)
+	}
+	if s := stats.Groups[gee.Name()]; s.Gets != 2 || s.Hits != 1 || s.LocalLoads != 1 {
+		t.Fatalf("unexpected group stats %+v", s)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	gee := NewGroup("metrics", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	gee.Get("Tom")
+	_, srvB := newTestPeer()
+	defer srvB.Close()
+	pool := NewHTTPPool("http://self")
+	pool.Set("http://self", srvB.URL)
+	pool.httpGetters[srvB.URL].Get(&pb.Request{Group: gee.name, Key: "Tom"}, &pb.Response{})
+
+	w := httptest.NewRecorder()
+	MetricsHandler(pool).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := ioutil.ReadAll(w.Body)
+	for _, line := range []string{
+		`geecache_gets_total{group="metrics"} 2`, // srvB 与本进程共享 groups 远程请求也计入
+		`geecache_hits_total{group="metrics"} 1`,
+		`geecache_local_loads_total{group="metrics"} 1`,
+		`geecache_cache_items{group="metrics",cache="main"} 1`,
+		`geecache_peer_request_duration_seconds_count{peer="` + srvB.URL + `"} 1`,
+		`geecache_peer_request_duration_seconds_bucket{peer="` + srvB.URL + `",le="+Inf"} 1`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("metrics should contain %s", line)
+		}
+	}
+	if strings.Contains(string(body), `peer="http://self"`) {
+		t.Error("self should not be reported as a peer")
+	}
+}
+
+func TestNotFoundAcrossPeers(t *testing.T) {
+	NewGroup("missing", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s not exist: %w", key, ErrNotFound)
+		}))
+	_, srv := newTestPeer()
+	defer srv.Close()
+
+	getter := newHTTPGetter(srv.URL + defaultBasePath)
+	err := getter.Get(&pb.Request{Group: "missing", Key: "Tom"}, &pb.Response{})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound from peer, got %v", err)
+	}
+	err = getter.Get(&pb.Request{Group: "no-such-group", Key: "Tom"}, &pb.Response{})
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("missing group should be a generic failure, got %v", err)
+	}
+
+	// 响应体无法解析时 只有带有 notFoundHeader 的 404 才表示不存在
+	notFound := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(notFoundHeader, "1")
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer notFound.Close()
+	getter = newHTTPGetter(notFound.URL + defaultBasePath)
+	if err = getter.Get(&pb.Request{Group: "missing", Key: "Tom"}, &pb.Response{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("404 with %s should be ErrNotFound, got %v", notFoundHeader, err)
+	}
+}
+
+// 代理等返回的错误响应体不是 pb.Response 不能阻止本地加载
+func TestProxyErrors(t *testing.T) {
+	var status int
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", status)
+	}))
+	defer proxy.Close()
+	getter := newHTTPGetter(proxy.URL + defaultBasePath)
+	for _, status = range []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusNotFound} {
+		err := getter.Get(&pb.Request{Group: "proxied", Key: "Tom"}, &pb.Response{})
+		var perr *PeerError
+		if !errors.As(err, &perr) || perr.Code != pb.Code_UNAVAILABLE || !shouldFallback(err) {
+			t.Errorf("status %d: expect UNAVAILABLE, got %v", status, err)
+		}
+	}
+}
+
+func TestServeHTTPStatus(t *testing.T) {
+	NewGroup("status", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			switch key {
+			case "missing":
+				return nil, ErrNotFound
+			case "broken":
+				return nil, fmt.Errorf("db is down")
+			}
+			return []byte(key), nil
+		}))
+	_, srv := newTestPeer()
+	defer srv.Close()
+
+	cases := []struct {
+		path   string
+		status int
+		code   pb.Code
+	}{
+		{"status/Tom", http.StatusOK, pb.Code_OK},
+		{"status/missing", http.StatusNotFound, pb.Code_NOT_FOUND},
+		{"status/broken", http.StatusInternalServerError, pb.Code_INTERNAL},
+		{"no-such-group/Tom", http.StatusServiceUnavailable, pb.Code_UNAVAILABLE},
+		{"bad-request", http.StatusBadRequest, pb.Code_INVALID_ARGUMENT},
+	}
+	getter := newHTTPGetter(srv.URL + defaultBasePath)
+	for _, c := range cases {
+		res, err := http.Get(srv.URL + defaultBasePath + c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != c.status {
+			t.Errorf("%s: expect status %d, got %d", c.path, c.status, res.StatusCode)
+		}
+		parts := strings.SplitN(c.path, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		err = getter.Get(&pb.Request{Group: parts[0], Key: parts[1]}, &pb.Response{})
+		var perr *PeerError
+		if c.code == pb.Code_OK && err != nil || c.code != pb.Code_OK && (!errors.As(err, &perr) || perr.Code != c.code) {
+			t.Errorf("%s: expect code %s, got %v", c.path, c.code, err)
+		}
+	}
+}
+
+func TestBatchOverHTTP(t *testing.T) {
+	NewGroup("batch", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist: %w", key, ErrNotFound)
+		}))
+	pool, srv := newTestPeer()
+	defer srv.Close()
+
+	getter := newHTTPGetter(srv.URL + defaultBasePath)
+	res := &pb.BatchResponse{}
+	err := getter.GetMulti(context.Background(), &pb.BatchRequest{Group: "batch", Keys: []string{"Tom", "unknown", "Jack"}}, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Responses) != 3 {
+		t.Fatalf("expect 3 responses, got %d", len(res.Responses))
+	}
+	if r := res.Responses[0]; r.Code != pb.Code_OK || string(r.Value) != "630" {
+		t.Errorf("Tom: got %v", r)
+	}
+	if r := res.Responses[1]; r.Code != pb.Code_NOT_FOUND {
+		t.Errorf("unknown: expect NOT_FOUND, got %v", r)
+	}
+	if r := res.Responses[2]; r.Code != pb.Code_OK || string(r.Value) != "345" {
+		t.Errorf("Jack: got %v", r)
+	}
+	if s := pool.Stats(); s.ServerRequests != 1 || s.ServerErrors != 0 {
+		t.Errorf("one batch should be one request without errors, got %+v", s)
+	}
+
+	err = getter.GetMulti(context.Background(), &pb.BatchRequest{Group: "no-such-group", Keys: []string{"Tom"}}, &pb.BatchResponse{})
+	if codeOf(err) != pb.Code_UNAVAILABLE {
+		t.Fatalf("missing group should be UNAVAILABLE, got %v", err)
+	}
+}
+
+// 统计请求次数的 RoundTripper
+type countingTransport struct {
+	requests AtomicInt
+}
+
+func (t *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestHTTPPoolOptions(t *testing.T) {
+	NewGroup("opts", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	server := NewHTTPPoolOpts("", &HTTPPoolOptions{BasePath: "/cache/"})
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	transport := &countingTransport{}
+	pool := NewHTTPPoolOpts("http://self", &HTTPPoolOptions{
+		BasePath:  "/cache/",
+		Replicas:  3,
+		HashFn:    func(data []byte) uint32 { return 0 }, // 所有 key 都落在同一个节点上
+		Transport: transport,
+	})
+	pool.Set(srv.URL)
+	peer, ok := pool.PickPeer("Tom")
+	if !ok {
+		t.Fatal("expect a remote peer")
+	}
+	out := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "opts", Key: "Tom"}, out); err != nil || string(out.Value) != "Tom" {
+		t.Fatalf("failed to get Tom through /cache/: %v", err)
+	}
+	if transport.requests.Get() != 1 {
+		t.Fatalf("expect the custom Transport to be used, got %d requests", transport.requests.Get())
+	}
+	if pool.opts.Client == http.DefaultClient {
+		t.Fatal("Transport should not change http.DefaultClient")
+	}
+}
+
+func TestHTTPPoolPlacement(t *testing.T) {
+	peers := []string{"http://a", "http://b", "http://c"}
+	placement := consistenthash.NewMaglev(0, nil)
+	placement.Add(peers...)
+	pool := NewHTTPPoolOpts("http://a", &HTTPPoolOptions{
+		Placement: func() consistenthash.Placement { return consistenthash.NewMaglev(0, nil) },
+	})
+	called := false
+	pool.OnPeersChanged(func(m []consistenthash.Range) { called = true })
+	pool.Set(peers...)
+	if called {
+		t.Fatal("observers should not be called without a hash ring")
+	}
+	for _, key := range []string{"Tom", "Jack", "Sam", "Kate"} {
+		owner := placement.Get(key)
+		peer, ok := pool.PickPeer(key)
+		if ok != (owner != "http://a") || (ok && peer.(*httpGetter).baseURL != owner+defaultBasePath) {
+			t.Fatalf("%s should be picked from %s", key, owner)
+		}
+	}
+	pool.RemovePeers("http://b")
+	if nodes := pool.peers.Nodes(); len(nodes) != 2 || nodes[1] != "http://c" {
+		t.Fatalf("unexpected nodes %v", nodes)
+	}
+}
+
+func TestBoundedLoad(t *testing.T) {
+	pool := NewHTTPPoolOpts("http://self", &HTTPPoolOptions{LoadBound: 0.25})
+	pool.Set("http://a", "http://b", "http://c") // 不包括自己 每次都选出远程节点
+	var (
+		key     string
+		owner   PeerGetter
+		release func()
+	)
+	for i := 0; owner == nil; i++ {
+		key = "key" + strconv.Itoa(i)
+		owner, release, _ = pool.PickPeerLoad(key)
+	}
+
+	// 选择节点时就计入负载 3 个节点 总负载为 1 时每个节点的容量为 ceil(1.25*2/3) = 1 owner 已满
+	peer, release2, _ := pool.PickPeerLoad(key)
+	if peer == owner {
+		t.Fatalf("%s should spill over to the next node while its owner is busy", key)
+	}
+	inFlight := 0
+	for _, s := range pool.Peers() {
+		inFlight += s.InFlight
+	}
+	if inFlight != 2 {
+		t.Fatalf("expect 2 requests in flight, got %d", inFlight)
+	}
+
+	release()
+	release() // 重复调用不会多减
+	release2()
+	if peer, _ := pool.PickPeer(key); peer != owner {
+		t.Fatalf("%s should return to its owner after the load is released", key)
+	}
+	for _, s := range pool.Peers() {
+		if s.InFlight != 0 {
+			t.Fatalf("all load should be released, %s has %d", s.Peer, s.InFlight)
+		}
+	}
+}
+
+func TestServeRing(t *testing.T) {
+	pool := NewHTTPPool("http://a")
+	pool.Set("http://a", "http://b")
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + defaultBasePath + ringPath + "?peers=http://a,http://b,http://c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var report RingReport
+	if err := json.NewDecoder(res.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Ownership) != 2 || len(report.After) != 3 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	for _, r := range report.Moved {
+		if r.To != "http://c" {
+			t.Fatalf("keys should only move to the new node, got %+v", r)
+		}
+	}
+	// 按哈希空间估计的移动比例应该与实际移动的 key 的比例接近
+	before := consistenthash.New(defaultReplicas, nil)
+	before.Add("http://a", "http://b")
+	after := consistenthash.New(defaultReplicas, nil)
+	after.Add("http://a", "http://b", "http://c")
+	moved := 0
+	for i := 0; i < 10000; i++ {
+		if key := "key" + strconv.Itoa(i); before.Get(key) != after.Get(key) {
+			moved++
+		}
+	}
+	if diff := report.MovedFraction - float64(moved)/10000; diff > 0.05 || diff < -0.05 {
+		t.Fatalf("moved fraction %.3f, but %d/10000 keys moved", report.MovedFraction, moved)
+	}
+	if got := report.After["http://c"]; got-report.MovedFraction > 1e-9 || report.MovedFraction-got > 1e-9 {
+		t.Fatalf("the new node should own exactly the moved ranges, got %v", got)
+	}
+
+	pool = NewHTTPPoolOpts("http://a", &HTTPPoolOptions{
+		Placement: func() consistenthash.Placement { return consistenthash.NewRendezvous(nil) },
+	})
+	if _, ok := pool.Ring(nil); ok {
+		t.Fatal("ring report needs the default hash ring")
+	}
+}
+
+func TestForwardingLoop(t *testing.T) {
+	var loads AtomicInt
+	gee := NewGroup("loop", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads.Add(1)
+			return []byte(key), nil
+		}))
+	server := NewHTTPPool("")
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	// 同一个进程中 server 使用的也是这个 group 它同样认为 key 属于 srv
+	// 没有转发保护时 srv 会把请求不断转发给自己
+	pool := NewHTTPPool("http://self")
+	pool.Set(srv.URL)
+	server.Set(srv.URL)
+	gee.RegisterPeers(pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if v, err := gee.GetContext(ctx, "Tom"); err != nil || v.String() != "Tom" {
+		t.Fatalf("failed to get Tom: %v", err)
+	}
+	if loads.Get() != 1 || server.Stats().ServerRequests != 1 {
+		t.Fatalf("forwarded request should be loaded locally, %d loads %d requests", loads.Get(), server.Stats().ServerRequests)
+	}
+	if server.Stats().RingMismatches != 0 {
+		t.Fatal("same peer lists should have the same version")
+	}
+
+	server.Set(srv.URL, "http://other")
+	if _, err := gee.GetContext(ctx, "Jack"); err != nil {
+		t.Fatal(err)
+	}
+	if server.Stats().RingMismatches != 1 {
+		t.Fatal("server should detect the different peer list")
+	}
+}
+
+func TestPushOverHTTP(t *testing.T) {
+	gee := NewGroup("push", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s should be pushed", key)
+		}))
+	srv := httptest.NewServer(NewHTTPPool(""))
+	defer srv.Close()
+
+	pool := NewHTTPPoolOpts("http://self", &HTTPPoolOptions{ReplicationFactor: 2})
+	pool.Set("http://self", srv.URL)
+	replicas := pool.PickReplicas("Tom")
+	if len(replicas) != 2 || (replicas[0] == nil) == (replicas[1] == nil) {
+		t.Fatalf("expect self and %s as replicas, got %v", srv.URL, replicas)
+	}
+	peer := replicas[0]
+	if peer == nil {
+		peer = replicas[1]
+	}
+	expire := time.Now().Add(time.Minute).Round(0)
+	req := &pb.PushRequest{Group: "push", Key: "Tom", Value: []byte("630"), Expire: expire.UnixNano()}
+	if err := peer.(PeerPusher).Push(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	v, err := gee.Get("Tom")
+	if err != nil || v.String() != "630" || !v.Expire().Equal(expire) {
+		t.Fatalf("pushed value should be cached, got %v %v", v, err)
+	}
+	req.Group = "unknown"
+	if err := peer.(PeerPusher).Push(context.Background(), req); err == nil {
+		t.Fatal("expect error for unknown group")
+	}
+	if NewHTTPPool("http://self").PickReplicas("Tom") != nil {
+		t.Fatal("replication is disabled by default")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("LoadBound with ReplicationFactor should panic")
+		}
+	}()
+	NewHTTPPoolOpts("http://self", &HTTPPoolOptions{LoadBound: 0.25, ReplicationFactor: 2})
+}
+
+// 可以模拟宕机的测试节点 down 时直接断开连接
+type flakyHandler struct {
+	http.Handler
+	mu   sync.Mutex
+	down bool
+}
+
+func (f *flakyHandler) setDown(down bool) {
+	f.mu.Lock()
+	f.down = down
+	f.mu.Unlock()
+}
+
+func (f *flakyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	down := f.down
+	f.mu.Unlock()
+	if down {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+		return
+	}
+	f.Handler.ServeHTTP(w, r)
+}
+
+func TestPeerHealth(t *testing.T) {
+	NewGroup("health", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	peer := &flakyHandler{Handler: NewHTTPPool("")}
+	srv := httptest.NewServer(peer)
+	defer srv.Close()
+
+	// 被动检查 连续两次请求失败后移出哈希环
+	pool := NewHTTPPoolOpts("http://self", &HTTPPoolOptions{FailureThreshold: 2})
+	defer pool.Close()
+	pool.Set("http://self", srv.URL)
+	peer.setDown(true)
+	getter := pool.httpGetters[srv.URL]
+	req := &pb.Request{Group: "health", Key: "Tom"}
+	for i := 0; i < 2; i++ {
+		if err := getter.Get(req, &pb.Response{}); err == nil {
+			t.Fatal("expect request to a down peer to fail")
+		}
+	}
+	if nodes := pool.peers.Nodes(); len(nodes) != 1 || nodes[0] != "http://self" {
+		t.Fatalf("down peer should be ejected, ring has %v", nodes)
+	}
+	if _, ok := pool.PickPeer("Tom"); ok {
+		t.Fatal("keys should be served locally when the only peer is ejected")
+	}
+	pool.Set("http://self", srv.URL) // 重新设置节点不会把未恢复的节点加回来
+	if len(pool.peers.Nodes()) != 1 {
+		t.Fatal("Set should keep the peer ejected")
+	}
+
+	peer.setDown(false)
+	pool.probeAll(time.Second)
+	if len(pool.peers.Nodes()) != 2 {
+		t.Fatal("peer should be restored after a successful probe")
+	}
+
+	// 主动检查 不需要请求也能发现节点宕机和恢复
+	active := NewHTTPPoolOpts("http://self", &HTTPPoolOptions{HealthCheckInterval: 5 * time.Millisecond, FailureThreshold: 2})
+	defer active.Close()
+	active.Set("http://self", srv.URL)
+	peer.setDown(true)
+	healthy := func() bool {
+		for _, s := range active.Peers() {
+			if s.Peer == srv.URL {
+				return s.Healthy
+			}
+		}
+		return false
+	}
+	waitFor(t, func() bool { return !healthy() })
+	peer.setDown(false)
+	waitFor(t, healthy)
+
+	rec := httptest.NewRecorder()
+	active.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, defaultBasePath+peersPath, nil))
+	var peers []PeerStatus
+	if err := json.NewDecoder(rec.Body).Decode(&peers); err != nil {
+		t.Fatal(err)
+	}
+	expect := []PeerStatus{{Peer: srv.URL, Healthy: true}, {Peer: "http://self", Self: true, Healthy: true}}
+	if srv.URL > "http://self" {
+		expect[0], expect[1] = expect[1], expect[0]
+	}
+	if !reflect.DeepEqual(peers, expect) {
+		t.Fatalf("unexpected _peers response %+v", peers)
+	}
+}
+
+// 没有响应的节点 不开启主动探测 也能通过请求超时被移出哈希环
+func TestPeerTimeout(t *testing.T) {
+	var loads AtomicInt
+	gee := NewGroup("black-hole", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads.Add(1)
+			return []byte(key), nil
+		}))
+	var requests AtomicInt
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-block // 不返回响应 也不断开连接
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	pool := NewHTTPPoolOpts("http://self", &HTTPPoolOptions{FailureThreshold: 2, PeerTimeout: 20 * time.Millisecond})
+	defer pool.Close()
+	pool.Set(srv.URL)
+	gee.RegisterPeers(pool)
+	for _, key := range []string{"Tom", "Jack", "Sam"} {
+		if v, err := gee.Get(key); err != nil || v.String() != key {
+			t.Fatalf("expect %s loaded locally, got %v %v", key, v, err)
+		}
+	}
+	if requests.Get() != 2 || loads.Get() != 3 {
+		t.Fatalf("peer should be ejected after 2 timeouts, got %d requests %d loads", requests.Get(), loads.Get())
+	}
+	if s := pool.Peers(); len(s) != 1 || s[0].Healthy || s[0].Failures != 2 {
+		t.Fatalf("unexpected peer status %+v", s)
+	}
+}
+
+// 恢复的节点保持原来的权重
+func TestPeerHealthWeight(t *testing.T) {
+	pool := NewHTTPPoolOpts("http://self", &HTTPPoolOptions{FailureThreshold: 1})
+	defer pool.Close()
+	pool.Set("http://self", "http://b")
+	ring := pool.peers.(*consistenthash.Map)
+	ring.AddWeighted("http://b", 3)
+	pool.report("http://b", errors.New("connection refused"))
+	if ring.Weight("http://b") != 0 {
+		t.Fatal("b should be ejected")
+	}
+	pool.report("http://b", nil)
+	if w := ring.Weight("http://b"); w != 3 {
+		t.Fatalf("b should be restored with weight 3, got %d", w)
+	}
+}
+
+// 使用 Jump 时 key 的归属与 Set 的顺序无关 被移出的节点恢复后回到原来的位置
+func TestJumpPlacementOrder(t *testing.T) {
+	opts := &HTTPPoolOptions{
+		FailureThreshold: 1,
+		Placement:        func() consistenthash.Placement { return consistenthash.NewJump(nil) },
+	}
+	a := NewHTTPPoolOpts("http://a", opts)
+	defer a.Close()
+	a.Set("http://a", "http://b", "http://c", "http://d")
+	b := NewHTTPPoolOpts("http://a", opts)
+	defer b.Close()
+	b.Set("http://d", "http://c", "http://b", "http://a")
+	owners := assignKeys(a)
+	if !reflect.DeepEqual(owners, assignKeys(b)) {
+		t.Fatal("owners should not depend on the order of Set")
+	}
+
+	a.report("http://b", errors.New("connection refused"))
+	a.report("http://b", nil)
+	if !reflect.DeepEqual(owners, assignKeys(a)) {
+		t.Fatal("restored peer should own the same keys")
+	}
+}
+
+func assignKeys(p *HTTPPool) []string {
+	owners := make([]string, 100)
+	for i := range owners {
+		owners[i] = p.peers.Get("key" + strconv.Itoa(i))
+	}
+	return owners
+}
@@ -0,0 +1,176 @@
+package geecache
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Prometheus 文本格式的指标 不依赖 client_golang
+格式说明 https://prometheus.io/docs/instrumenting/exposition_formats/
+*/
+
+// 与 Prometheus 默认一致的延迟分桶 单位为秒
+var defaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram 是一个简单的累积直方图
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64 // 各个桶的上界 升序
+	counts  []uint64  // counts[i] 为落在 (buckets[i-1], buckets[i]] 的次数 最后一个为 +Inf
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+// 记录一次耗时
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	i := sort.SearchFloat64s(h.buckets, v) // 第一个 >= v 的上界
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// 以 Prometheus 格式写出 name_bucket name_sum name_count 三组样本
+func (h *histogram) write(buf *bytes.Buffer, name string, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var cumulative uint64
+	for i, le := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(buf, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+	}
+	cumulative += h.counts[len(h.buckets)]
+	fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, cumulative)
+	fmt.Fprintf(buf, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(buf, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+// 转义标签值中的 \ " 和换行
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func label(name, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+// 写出一个指标的 HELP 和 TYPE 注释
+func writeHeader(buf *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// MetricsHandler 返回 /metrics 接口 以 Prometheus 文本格式输出
+// 所有 group 的计数器和缓存大小 以及 HTTPPool 到各个远程节点的请求延迟
+// p 为 nil 时只输出 group 的指标
+func MetricsHandler(p *HTTPPool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		writeGroupMetrics(&buf)
+		if p != nil {
+			p.writeMetrics(&buf)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(buf.Bytes())
+	})
+}
+
+func writeGroupMetrics(buf *bytes.Buffer) {
+	all := allGroups()
+	names := make([]string, 0, len(all))
+	stats := make(map[string]Stats, len(all))
+	for name, g := range all {
+		names = append(names, name)
+		stats[name] = g.Stats()
+	}
+	sort.Strings(names)
+
+	counters := []struct {
+		name, help string
+		value      func(s Stats) int64
+	}{
+		{"geecache_gets_total", "Get requests.", func(s Stats) int64 { return s.Gets }},
+		{"geecache_hits_total", "Get requests served from mainCache or hotCache.", func(s Stats) int64 { return s.Hits }},
+		{"geecache_misses_total", "Get requests that needed a load.", func(s Stats) int64 { return s.Misses }},
+		{"geecache_peer_loads_total", "Successful loads from remote peers.", func(s Stats) int64 { return s.PeerLoads }},
+		{"geecache_peer_errors_total", "Failed loads from remote peers.", func(s Stats) int64 { return s.PeerErrors }},
+		{"geecache_local_loads_total", "Successful loads from the Getter.", func(s Stats) int64 { return s.LocalLoads }},
+		{"geecache_local_load_errors_total", "Failed loads from the Getter.", func(s Stats) int64 { return s.LocalLoadErrs }},
+		{"geecache_dedups_total", "Loads deduplicated by singleflight.", func(s Stats) int64 { return s.Dedups }},
+		{"geecache_stale_hits_total", "Expired values served while refreshing in the background.", func(s Stats) int64 { return s.StaleHits }},
+		{"geecache_refreshes_total", "Background refreshes started.", func(s Stats) int64 { return s.Refreshes }},
+		{"geecache_peer_retries_total", "Retries of failed peer requests.", func(s Stats) int64 { return s.PeerRetries }},
+		{"geecache_breaker_rejects_total", "Peer requests rejected by an open circuit breaker.", func(s Stats) int64 { return s.BreakerRejects }},
+		{"geecache_hedges_total", "Hedged requests sent after the primary peer was slow.", func(s Stats) int64 { return s.Hedges }},
+		{"geecache_hedge_wins_total", "Hedged requests that answered before the primary peer.", func(s Stats) int64 { return s.HedgeWins }},
+		{"geecache_pushes_total", "Values pushed to replicas after a primary load.", func(s Stats) int64 { return s.Pushes }},
+		{"geecache_push_errors_total", "Pushes to replicas that failed.", func(s Stats) int64 { return s.PushErrors }},
+	}
+	for _, c := range counters {
+		writeHeader(buf, c.name, "counter", c.help)
+		for _, name := range names {
+			fmt.Fprintf(buf, "%s{%s} %d\n", c.name, label("group", name), c.value(stats[name]))
+		}
+	}
+
+	caches := []struct {
+		name, typ, help string
+		value           func(s CacheStats) int64
+	}{
+		{"geecache_cache_bytes", "gauge", "Bytes used by the lru.Cache.", func(s CacheStats) int64 { return s.Bytes }},
+		{"geecache_cache_items", "gauge", "Items stored in the lru.Cache.", func(s CacheStats) int64 { return s.Items }},
+		{"geecache_cache_evictions_total", "counter", "Items evicted for capacity or expiration.", func(s CacheStats) int64 { return s.Evictions }},
+	}
+	for _, c := range caches {
+		writeHeader(buf, c.name, c.typ, c.help)
+		for _, name := range names {
+			fmt.Fprintf(buf, "%s{%s,cache=\"main\"} %d\n", c.name, label("group", name), c.value(stats[name].MainCache))
+			fmt.Fprintf(buf, "%s{%s,cache=\"hot\"} %d\n", c.name, label("group", name), c.value(stats[name].HotCache))
+		}
+	}
+}
+
+func (p *HTTPPool) writeMetrics(buf *bytes.Buffer) {
+	s := p.Stats()
+	writeHeader(buf, "geecache_server_requests_total", "counter", "Get requests received from peers.")
+	fmt.Fprintf(buf, "geecache_server_requests_total %d\n", s.ServerRequests)
+	writeHeader(buf, "geecache_server_errors_total", "counter", "Get requests from peers that failed.")
+	fmt.Fprintf(buf, "geecache_server_errors_total %d\n", s.ServerErrors)
+	writeHeader(buf, "geecache_invalidations_total", "counter", "Invalidations received from peers.")
+	fmt.Fprintf(buf, "geecache_invalidations_total %d\n", s.Invalidations)
+	writeHeader(buf, "geecache_ring_mismatches_total", "counter", "Requests from peers with a different peer list.")
+	fmt.Fprintf(buf, "geecache_ring_mismatches_total %d\n", s.RingMismatches)
+	writeHeader(buf, "geecache_pushes_received_total", "counter", "Values pushed by primary peers.")
+	fmt.Fprintf(buf, "geecache_pushes_received_total %d\n", s.Pushes)
+
+	p.mu.Lock()
+	peers := make([]string, 0, len(p.httpGetters))
+	getters := make(map[string]*httpGetter, len(p.httpGetters))
+	for peer, getter := range p.httpGetters {
+		if peer != p.self {
+			peers = append(peers, peer)
+			getters[peer] = getter
+		}
+	}
+	p.mu.Unlock()
+	sort.Strings(peers)
+
+	const name = "geecache_peer_request_duration_seconds"
+	writeHeader(buf, name, "histogram", "Latency of Get requests sent to each peer.")
+	for _, peer := range peers {
+		getters[peer].latency.write(buf, name, label("peer", peer))
+	}
+}
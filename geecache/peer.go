@@ -2,6 +2,18 @@ package geecache
 
 import (
 	pb "Cache/geecache/geecachepb"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 删除广播失败后的重试次数 以及每次重试前等待的基础时间
+	invalidateRetries = 3
+	invalidateBackoff = 100 * time.Millisecond
 )
 
 // 用于根据传入的key 选择相应节点 PeerGetter
@@ -9,8 +21,129 @@ type PeerPicker interface {
 	PickPeer(key string) (peer PeerGetter, ok bool)
 }
 
+// 可选接口 PeerPicker 同时实现它时 Group 可以向负责 key 的第二个节点发送对冲请求 见 WithHedgeDelay
+// PickPeers 按哈希环上的顺序返回负责 key 的前 n 个节点 自己对应的元素为 nil
+type PeersPicker interface {
+	PickPeers(key string, n int) []PeerGetter
+}
+
+// 可选接口 PeerPicker 同时实现它时 Group 用 PickPeerLoad 代替 PickPeer 选择节点 见 HTTPPoolOptions.LoadBound
+// 选择节点的同时把请求计入该节点的负载 并发的选择能看到彼此 请求结束后调用 release
+type LoadPicker interface {
+	PickPeerLoad(key string) (peer PeerGetter, release func(), ok bool)
+}
+
+// 可选接口 PeerPicker 同时实现它时 每个 key 存放在多个节点上 见 HTTPPoolOptions.ReplicationFactor
+// PickReplicas 按顺序返回存放 key 的节点 第一个为主节点 自己对应的元素为 nil 不足 2 个表示没有副本
+type ReplicaPicker interface {
+	PickReplicas(key string) []PeerGetter
+}
+
+// 可选接口 PeerGetter 同时实现它时 主节点加载的值会被推送到这个节点上
+type PeerPusher interface {
+	Push(ctx context.Context, in *pb.PushRequest) error
+}
+
+// 可选接口 PeerPicker 同时实现它时 节点被删除后 Group 会清理与该节点有关的状态 如熔断器
+// fn 在节点池释放锁之后调用 参数为被删除节点原来的 PeerGetter
+type PeerRemovalNotifier interface {
+	OnPeerRemoved(fn func(peer PeerGetter))
+}
+
+// removalHooks 保存 OnPeerRemoved 注册的回调 HTTPPool 和 GRPCPool 共用
+type removalHooks struct {
+	mu  sync.Mutex
+	fns []func(peer PeerGetter)
+}
+
+func (h *removalHooks) add(fn func(peer PeerGetter)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fns = append(h.fns, fn)
+}
+
+// 通知被删除的 peers 调用方不能持有节点池的锁
+func (h *removalHooks) notify(peers []PeerGetter) {
+	if len(peers) == 0 {
+		return
+	}
+	h.mu.Lock()
+	fns := h.fns
+	h.mu.Unlock()
+	for _, peer := range peers {
+		for _, fn := range fns {
+			fn(peer)
+		}
+	}
+}
+
 // 用于从对应group查找缓存值，PeerGetter 对应于上述流程中的 HTTP 客户端
 type PeerGetter interface {
 	//Get(group string, key string) ([]byte, error)
 	Get(in *pb.Request, out *pb.Response) error
 }
+
+// 可选接口 PeerGetter 同时实现它时 getFromPeer 会把调用方的 ctx 传给远程调用
+// 取消或超时后远程调用会被中断
+type PeerGetterContext interface {
+	GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error
+}
+
+// 可选接口 PeerPicker 同时实现它时 Group.Remove 会把删除广播给所有远程节点
+// 返回的 error 说明有节点没有确认删除 这些节点上可能仍是旧值
+type PeerInvalidator interface {
+	Invalidate(group string, key string) error
+}
+
+// invalidator 由各传输方式的客户端实现（httpGetter grpcGetter） 通知单个远程节点删除缓存
+type invalidator interface {
+	invalidate(in *pb.InvalidateRequest) error
+}
+
+// 并发地把删除请求发给 peers 每个节点失败后线性退避重试
+// peers 的 key 为节点地址 用于在 InvalidateError 中报告失败的节点
+func broadcastInvalidate(peers map[string]invalidator, req *pb.InvalidateRequest, logf func(format string, v ...interface{})) error {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed = make(map[string]error)
+	)
+	for peer, getter := range peers {
+		wg.Add(1)
+		go func(peer string, getter invalidator) {
+			defer wg.Done()
+			var err error
+			for i := 0; i <= invalidateRetries; i++ {
+				if i > 0 {
+					time.Sleep(time.Duration(i) * invalidateBackoff) // 线性退避
+				}
+				if err = getter.invalidate(req); err == nil {
+					return
+				}
+			}
+			logf("invalidate %s/%s on %s failed: %v", req.GetGroup(), req.GetKey(), peer, err)
+			mu.Lock()
+			failed[peer] = err
+			mu.Unlock()
+		}(peer, getter)
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		return &InvalidateError{Failed: failed}
+	}
+	return nil
+}
+
+// InvalidateError 记录删除广播中没有确认的节点 以及各自最后一次的错误
+type InvalidateError struct {
+	Failed map[string]error
+}
+
+func (e *InvalidateError) Error() string {
+	peers := make([]string, 0, len(e.Failed))
+	for peer := range e.Failed {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return fmt.Sprintf("invalidate not acknowledged by %d peer(s): %s", len(peers), strings.Join(peers, ", "))
+}
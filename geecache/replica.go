@@ -0,0 +1,255 @@
+package geecache
+
+import (
+	pb "Cache/geecache/geecachepb"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+多副本
+开启 HTTPPoolOptions.ReplicationFactor 后 每个 key 存放在哈希环上前 N 个不同的节点上
+加载时依次尝试各个副本 主节点宕机后由下一个副本提供数据 不必全部回源
+主节点从数据源加载成功后 通过 basePath/_push 把值异步推送给其他副本
+同时进行的推送最多 maxPushes 个 超出的直接放弃 副本之后会自己加载
+删除之前发出的推送可能在删除之后才到达 副本丢弃 pushTimeout 之内被删除过的 key 的推送
+可以与 WithHedgeDelay 和 GetMulti 一起使用 不能与 HTTPPoolOptions.LoadBound 一起使用
+*/
+
+const (
+	pushPath    = "_push"
+	pushTimeout = 5 * time.Second // 每次推送最多等待的时间
+	maxPushes   = 64              // 每个 group 同时进行的推送数上限
+)
+
+// 返回存放 key 的所有副本 没有开启多副本时返回 nil
+func (g *Group) replicas(key string) []PeerGetter {
+	picker, ok := g.peers.(ReplicaPicker)
+	if !ok {
+		return nil
+	}
+	if replicas := picker.PickReplicas(key); len(replicas) > 1 {
+		return replicas
+	}
+	return nil
+}
+
+// 从第 start 个副本开始依次尝试存放 key 的各个副本 轮到自己时从本地加载
+// 远程副本失败后的处理与只有一个节点时相同 全部失败后从本地加载
+// 开启对冲时 第一个副本的对冲请求发给下一个副本或者本地加载
+func (g *Group) getFromReplicas(ctx context.Context, key string, replicas []PeerGetter, start int) (ByteView, error) {
+	for i := start; i < len(replicas); i++ {
+		peer := replicas[i]
+		if peer == nil {
+			return g.getLocallyAndPush(ctx, key, replicas)
+		}
+		var (
+			value ByteView
+			err   error
+		)
+		if i == start {
+			var local bool
+			if value, local, err = g.getFromPeerHedged(ctx, peer, key); local { // 统计由 getLoacally 完成
+				return value, err
+			}
+		} else {
+			value, err = g.getFromPeer(ctx, peer, key)
+		}
+		if err == nil || errors.Is(err, ErrNotFound) {
+			g.stats.PeerLoads.Add(1)
+			return value, err
+		}
+		g.stats.PeerErrors.Add(1)
+		log.Println("[GeeCache] Failed to get from replica", err)
+		if !shouldFallback(err) {
+			return ByteView{}, err
+		}
+		if ctx.Err() != nil {
+			return ByteView{}, ctx.Err()
+		}
+	}
+	return g.getLoacally(ctx, key)
+}
+
+// GetMulti 中主节点 primary 失败的 keys 依次尝试其他副本 结果写入 b 返回仍需本地加载的 key
+func (g *Group) getMultiFromReplicas(ctx context.Context, primary PeerGetter, keys []string, b *batch) (local []string) {
+	for _, key := range keys {
+		replicas := g.replicas(key)
+		if replicas == nil || replicas[0] != primary || ctx.Err() != nil {
+			local = append(local, key)
+			continue
+		}
+		value, err := g.getFromReplicas(ctx, key, replicas, 1)
+		b.set(key, value, err)
+	}
+	return local
+}
+
+// 从本地加载 自己是主节点时把加载的值异步推送给其他副本
+func (g *Group) getLocallyAndPush(ctx context.Context, key string, replicas []PeerGetter) (ByteView, error) {
+	value, err := g.getLoacally(ctx, key)
+	if err == nil && len(replicas) > 1 && replicas[0] == nil {
+		g.pushToReplicas(key, value, replicas[1:])
+	}
+	return value, err
+}
+
+// 并发地把 value 推送给 peers 不等待结果 推送失败只记录日志 副本之后会自己加载
+// 已经有 maxPushes 个推送在进行时放弃 记为推送失败
+func (g *Group) pushToReplicas(key string, value ByteView, peers []PeerGetter) {
+	req := &pb.PushRequest{Group: g.name, Key: key, Value: value.b, Expire: value.expireUnixNano()}
+	for _, peer := range peers {
+		pusher, ok := peer.(PeerPusher)
+		if !ok {
+			continue
+		}
+		select {
+		case g.pushing <- struct{}{}:
+		default:
+			g.stats.PushErrors.Add(1)
+			continue
+		}
+		g.stats.Pushes.Add(1)
+		go func(pusher PeerPusher) {
+			defer func() { <-g.pushing }()
+			ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+			defer cancel()
+			if err := pusher.Push(ctx, req); err != nil {
+				g.stats.PushErrors.Add(1)
+				log.Println("[GeeCache] Failed to push to replica", err)
+			}
+		}(pusher)
+	}
+}
+
+// 保存推送来的值 返回 false 表示 key 最近被删除过 推送被丢弃
+// 写入之后再检查一次 期间收到删除时把写入的值删掉
+func (g *Group) populatePushed(key string, value ByteView) bool {
+	if g.removed.recent(key) {
+		return false
+	}
+	g.populateCache(key, value)
+	if g.removed.recent(key) {
+		g.mainCache.remove(key)
+		return false
+	}
+	return true
+}
+
+// tombstones 记录最近 pushTimeout 之内被删除的 key
+type tombstones struct {
+	mu    sync.Mutex
+	at    map[string]time.Time
+	queue []tombstone // 按删除时间排序 用于清理过期的记录
+}
+
+type tombstone struct {
+	key string
+	at  time.Time
+}
+
+func (t *tombstones) add(key string) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(now)
+	if t.at == nil {
+		t.at = make(map[string]time.Time)
+	}
+	t.at[key] = now
+	t.queue = append(t.queue, tombstone{key, now})
+}
+
+// key 是否在 pushTimeout 之内被删除过
+func (t *tombstones) recent(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(time.Now())
+	_, ok := t.at[key]
+	return ok
+}
+
+// 清理超过 pushTimeout 的记录 调用前持有 t.mu
+func (t *tombstones) prune(now time.Time) {
+	i := 0
+	for ; i < len(t.queue) && now.Sub(t.queue[i].at) >= pushTimeout; i++ {
+		if r := t.queue[i]; t.at[r.key].Equal(r.at) { // key 之后又被删除过时保留
+			delete(t.at, r.key)
+		}
+	}
+	t.queue = t.queue[i:]
+}
+
+// PickReplicas 返回负责 key 的前 ReplicationFactor 个节点对应的 HTTP 客户端 自己对应的元素为 nil
+func (p *HTTPPool) PickReplicas(key string) []PeerGetter {
+	if p.opts.ReplicationFactor <= 1 {
+		return nil
+	}
+	return p.PickPeers(key, p.opts.ReplicationFactor)
+}
+
+// 保存主节点推送来的值
+func (p *HTTPPool) servePush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &pb.PushRequest{}
+	if err = proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	group := GetGroup(req.GetGroup())
+	if group == nil {
+		http.Error(w, "no such group:"+req.GetGroup(), http.StatusNotFound)
+		return
+	}
+	p.pushes.Add(1)
+	value := ByteView{b: req.GetValue()}
+	if req.GetExpire() != 0 {
+		value.e = time.Unix(0, req.GetExpire())
+	}
+	if !group.populatePushed(req.GetKey(), value) {
+		p.Log("drop push of %s, removed recently", req.GetKey())
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Push 把主节点加载的值推送给远程节点
+func (h *httpGetter) Push(ctx context.Context, in *pb.PushRequest) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, h.baseURL+pushPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	res, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returnes: %v", res.Status)
+	}
+	return nil
+}
+
+var _ ReplicaPicker = (*HTTPPool)(nil)
+var _ PeerPusher = (*httpGetter)(nil)
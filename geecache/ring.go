@@ -0,0 +1,72 @@
+package geecache
+
+import (
+	"Cache/geecache/consistenthash"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+/*
+哈希环的诊断信息 basePath/_ring 返回每个节点负责的哈希空间比例
+basePath/_ring?peers=a,b,c 额外返回把节点换成 a b c 之后各节点的比例 以及需要移动的区间
+用于在增删节点前评估负载和迁移量
+*/
+
+const ringPath = "_ring"
+
+// RingReport 是 HTTPPool.Ring 和 _ring 接口返回的哈希环信息
+type RingReport struct {
+	Ownership map[string]float64 `json:"ownership"` // 每个节点负责的哈希空间比例 不包括被移出的节点
+
+	// 以下字段只在指定了 peers 时返回
+	After         map[string]float64     `json:"after,omitempty"` // 换成 peers 之后每个节点的比例
+	Moved         []consistenthash.Range `json:"moved,omitempty"` // 归属发生变化的区间
+	MovedFraction float64                `json:"moved_fraction"`  // 需要移动的 key 的比例
+}
+
+// Ring 返回当前哈希环的信息 peers 不为 nil 时与由 peers 构成的新环比较
+// 使用其他 Placement 时无法按哈希空间统计 返回 false
+func (p *HTTPPool) Ring(peers []string) (RingReport, bool) {
+	p.mu.Lock()
+	var ring *consistenthash.Map
+	if p.peers == nil {
+		ring, _ = p.newRing().(*consistenthash.Map)
+	} else if m, ok := p.peers.(*consistenthash.Map); ok {
+		ring = m.Clone()
+	}
+	p.mu.Unlock()
+	if ring == nil {
+		return RingReport{}, false
+	}
+
+	report := RingReport{Ownership: ring.Ownership()}
+	if peers != nil {
+		after := consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+		after.Add(peers...)
+		report.After = after.Ownership()
+		report.Moved = ring.Diff(after)
+		report.MovedFraction = consistenthash.MovedFraction(report.Moved)
+	}
+	return report, true
+}
+
+// 以 JSON 返回哈希环的信息 ?peers= 为逗号分隔的节点地址
+func (p *HTTPPool) serveRing(w http.ResponseWriter, r *http.Request) {
+	var peers []string
+	if v, ok := r.URL.Query()["peers"]; ok {
+		peers = []string{}
+		for _, peer := range strings.Split(v[0], ",") {
+			if peer = strings.TrimSpace(peer); peer != "" {
+				peers = append(peers, peer)
+			}
+		}
+	}
+	report, ok := p.Ring(peers)
+	if !ok {
+		http.Error(w, "ring diagnostics need the default hash ring", http.StatusNotImplemented)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
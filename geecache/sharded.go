@@ -0,0 +1,113 @@
+package geecache
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+分片缓存
+cache 用一把锁保护整个 lru 由于 get 也要移动链表节点 读请求同样需要互斥锁 并发高时锁成为瓶颈
+shardedCache 按 key 的哈希把数据分到 n 个独立的 cache 中 每个分片有自己的锁和容量
+不同 key 的读写大多落在不同分片上 互不等待
+代价是淘汰只在分片内部进行 不是全局的 LRU
+所有分片共用一个后台清理协程
+*/
+
+type shardedCache struct {
+	shards        []*cache
+	sweepInterval time.Duration
+	sweepOnce     sync.Once // 第一次出现会过期的数据时启动清理协程
+	closeOnce     sync.Once
+	stop          chan struct{} // 关闭后清理协程退出 见 close
+}
+
+// 创建 n 个分片 cacheBytes 平均分给各个分片 余下的字节分给前几个分片
+// cacheBytes 不为 0 时每个分片至少 1 字节 0 表示不限制 不能让分片变成不限制
+func newShardedCache(n int, cacheBytes int64, config cacheConfig) *shardedCache {
+	s := &shardedCache{
+		shards:        make([]*cache, n),
+		sweepInterval: config.sweepInterval,
+		stop:          make(chan struct{}),
+	}
+	config.sweepInterval = 0 // 分片自己不启动清理协程
+	for i := range s.shards {
+		bytes := cacheBytes / int64(n)
+		if int64(i) < cacheBytes%int64(n) {
+			bytes++
+		}
+		if bytes == 0 && cacheBytes > 0 {
+			bytes = 1
+		}
+		s.shards[i] = &cache{cacheConfig: config, cacheBytes: bytes}
+	}
+	return s
+}
+
+// FNV-1a 哈希选择分片 不经过 hash.Hash 避免每次分配内存
+func (s *shardedCache) shard(key string) *cache {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return s.shards[h%uint32(len(s.shards))]
+}
+
+func (s *shardedCache) add(key string, value ByteView) {
+	s.shard(key).add(key, value)
+	if !value.Expire().IsZero() && s.sweepInterval > 0 {
+		s.sweepOnce.Do(func() { go s.sweep() })
+	}
+}
+
+// 定期清理所有分片中已过期的数据 每次只锁一个分片
+func (s *shardedCache) sweep() {
+	ticker := time.NewTicker(s.sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			for _, c := range s.shards {
+				c.removeExpired()
+			}
+		}
+	}
+}
+
+func (s *shardedCache) get(key string) (value ByteView, ok bool) {
+	return s.shard(key).get(key)
+}
+
+func (s *shardedCache) remove(key string) bool {
+	return s.shard(key).remove(key)
+}
+
+func (s *shardedCache) purge() {
+	for _, c := range s.shards {
+		c.purge()
+	}
+}
+
+func (s *shardedCache) close() {
+	s.closeOnce.Do(func() { close(s.stop) })
+}
+
+// 返回所有分片统计信息之和
+func (s *shardedCache) stats() CacheStats {
+	var total CacheStats
+	for _, c := range s.shards {
+		cs := c.stats()
+		total.Bytes += cs.Bytes
+		total.Items += cs.Items
+		total.Gets += cs.Gets
+		total.Hits += cs.Hits
+		total.Evictions += cs.Evictions
+	}
+	return total
+}
+
+var _ store = (*cache)(nil)
+var _ store = (*shardedCache)(nil)
@@ -1,12 +1,22 @@
 package singleflight
 
-import "sync"
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
-// call 代表正在进行中 货已经结束的请求 使用sync.WaitGroup 锁避免重入
+// call 代表正在进行中 货已经结束的请求 使用 done 通道等待请求结束 避免重入
 type call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+	done chan struct{} // 请求结束时关闭 等待方可以同时监听 ctx.Done()
+	val  interface{}
+	err  error
+
+	panicked interface{}        // fn panic 时的值
+	waiters  int                // 还在等待结果的调用者数 由 Group.mu 保护
+	cancel   context.CancelFunc // 取消 fn 使用的 ctx
 }
 
 // Group 是 singleflight 的主数据结构，管理不同key 的请求（call）
@@ -19,29 +29,160 @@ type Group struct {
 // Do 的作用是，针对相同的key，无论Do被调用多少次，函数fn都只会被调用一次，等待fn调用结束了，翻翻返回值或错误
 
 func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	return g.DoContext(context.Background(), key, func(context.Context) (interface{}, error) {
+		return fn()
+	})
+}
+
+// DoContext 与 Do 相同 但等待方可以通过 ctx 提前放弃等待
+// fn 在单独的协程中执行 使用的 ctx 带有第一个调用者 ctx 中的值 但不会随它取消
+// 只有所有等待者都放弃之后 fn 的 ctx 才会被取消 之后相同 key 的调用重新执行 fn
+func (g *Group) DoContext(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
 	g.mu.Lock() // 加锁
 	if g.m == nil {
 		g.m = make(map[string]*call)
 	}
 	/* 若请求正在处理中  这个判断是为了 多次请求不重复添加*/
-	if c, ok := g.m[key]; ok {
-		g.mu.Unlock()       //  解锁
-		c.wg.Wait()         // 如果请求正在进行中 则等待
-		return c.val, c.err // 请求结果， 返回结果
+	c, ok := g.m[key]
+	if !ok {
+		/* 若第一次发起请求 添加到处理列表 */
+		fctx, cancel := context.WithCancel(detached{ctx})
+		c = &call{done: make(chan struct{}), cancel: cancel}
+		g.m[key] = c // 添加到 g.m 中， 表明 key 已经有对应的请求在处理
+		go g.doCall(fctx, c, key, fn)
 	}
-
-	/* 若第一次发起请求 添加到处理列表 */
-	c := new(call)
-	c.wg.Add(1)   // 发起请求前加锁
-	g.m[key] = c  // 添加到 g.m 中， 表明 key 已经有对应的请求在处理
+	c.waiters++
 	g.mu.Unlock() // 解锁
 
-	c.val, c.err = fn() // 调用fn，发起请求
-	c.wg.Done()         // 请求结束
+	return g.wait(ctx, key, c)
+}
+
+// Result 是 DoMulti 中一个 key 的结果
+type Result struct {
+	Val    interface{}
+	Err    error
+	Shared bool // 结果来自其他调用者已经发起的请求 fn 没有加载这个 key
+}
 
+// DoMulti 是 DoContext 的批量版本 keys 中已经有请求在进行中的 key 等待原来的请求
+// 其余的 key 只调用一次 fn 一起加载 fn 的返回值中缺少的 key 视为失败
+// 与 DoContext 的调用共享同一批进行中的请求 keys 中不能有重复的 key
+func (g *Group) DoMulti(ctx context.Context, keys []string, fn func(ctx context.Context, keys []string) map[string]Result) map[string]Result {
 	g.mu.Lock()
-	delete(g.m, key) // 更新 g.m
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	calls := make(map[string]*call, len(keys))
+	shared := make(map[string]bool)
+	var own []string
+	fctx, cancel := context.WithCancel(detached{ctx})
+	var remaining int32 // 还有等待者的 call 数 降为 0 时取消 fn 的 ctx
+	for _, key := range keys {
+		c, ok := g.m[key]
+		if ok {
+			shared[key] = true
+		} else {
+			c = &call{done: make(chan struct{}), cancel: func() {
+				if atomic.AddInt32(&remaining, -1) == 0 {
+					cancel()
+				}
+			}}
+			g.m[key] = c
+			own = append(own, key)
+		}
+		c.waiters++
+		calls[key] = c
+	}
+	remaining = int32(len(own))
 	g.mu.Unlock()
+	if len(own) > 0 {
+		go g.doMulti(fctx, cancel, own, calls, fn)
+	} else {
+		cancel()
+	}
 
-	return c.val, c.err // 返回结果
+	results := make(map[string]Result, len(keys))
+	for _, key := range keys {
+		val, err := g.wait(ctx, key, calls[key])
+		results[key] = Result{Val: val, Err: err, Shared: shared[key]}
+	}
+	return results
 }
+
+// 为 keys 调用一次 fn 把结果分别交给每个 key 的 call
+func (g *Group) doMulti(ctx context.Context, cancel context.CancelFunc, keys []string, calls map[string]*call,
+	fn func(ctx context.Context, keys []string) map[string]Result) {
+	var results map[string]Result
+	var panicked interface{}
+	func() {
+		defer func() { panicked = recover() }()
+		results = fn(ctx, keys)
+	}()
+	cancel()
+	g.mu.Lock()
+	for _, key := range keys {
+		c := calls[key]
+		if r, ok := results[key]; ok {
+			c.val, c.err = r.Val, r.Err
+		} else {
+			c.err = fmt.Errorf("singleflight: no result for %s", key)
+		}
+		c.panicked = panicked
+		if g.m[key] == c {
+			delete(g.m, key)
+		}
+	}
+	g.mu.Unlock()
+	for _, key := range keys {
+		close(calls[key].done)
+	}
+}
+
+// 等待 c 结束 ctx 结束时放弃等待 最后一个等待者放弃后取消 c
+func (g *Group) wait(ctx context.Context, key string, c *call) (interface{}, error) {
+	select { // 等待请求结束
+	case <-c.done:
+		if c.panicked != nil {
+			panic(c.panicked)
+		}
+		return c.val, c.err // 请求结果， 返回结果
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 { // 最后一个等待者也放弃了 不必再执行 fn
+			c.cancel()
+			if g.m[key] == c {
+				delete(g.m, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// 调用 fn 保存结果 fn panic 时由每个等待者重新 panic
+func (g *Group) doCall(ctx context.Context, c *call, key string, fn func(ctx context.Context) (interface{}, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.panicked = r
+		}
+		c.cancel()
+		g.mu.Lock()
+		if g.m[key] == c { // 可能已经被放弃并换成了新的请求
+			delete(g.m, key) // 更新 g.m
+		}
+		g.mu.Unlock()
+		close(c.done) // 请求结束
+	}()
+	c.val, c.err = fn(ctx) // 调用fn，发起请求
+}
+
+// detached 保留 parent 中的值 但没有 deadline 也不会随 parent 取消
+type detached struct {
+	parent context.Context
+}
+
+func (d detached) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (d detached) Done() <-chan struct{}             { return nil }
+func (d detached) Err() error                        { return nil }
+func (d detached) Value(key interface{}) interface{} { return d.parent.Value(key) }
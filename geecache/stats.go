@@ -0,0 +1,97 @@
+package geecache
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// AtomicInt 是可以并发读写的 int64 计数器
+type AtomicInt int64
+
+// Add 原子地加上 n
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+// Get 原子地读取当前值
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+// Group 内部的计数器 都只增不减
+type groupStats struct {
+	Gets           AtomicInt // 所有 Get 请求
+	Hits           AtomicInt // 命中 mainCache 或 hotCache
+	Misses         AtomicInt // 未命中 需要加载
+	PeerLoads      AtomicInt // 从远程节点加载成功
+	PeerErrors     AtomicInt // 从远程节点加载失败
+	LocalLoads     AtomicInt // 调用回调函数加载成功
+	LocalLoadErrs  AtomicInt // 调用回调函数加载失败
+	Dedups         AtomicInt // 被 singleflight 合并 没有真正加载的请求
+	StaleHits      AtomicInt // 命中已过期的数据 返回旧值并在后台刷新
+	Refreshes      AtomicInt // 后台刷新的次数 包括 stale-while-revalidate 和 refresh-ahead
+	PeerRetries    AtomicInt // 远程请求的重试次数
+	BreakerRejects AtomicInt // 熔断器打开 没有发出的远程请求
+	Hedges         AtomicInt // 发出的对冲请求
+	HedgeWins      AtomicInt // 对冲请求先于主节点成功返回
+	Pushes         AtomicInt // 作为主节点推送给其他副本的次数
+	PushErrors     AtomicInt // 推送失败或者同时推送太多而放弃的次数
+}
+
+// Stats 是 Group 统计信息的快照 由 Group.Stats() 返回
+type Stats struct {
+	Gets           int64 `json:"gets"`
+	Hits           int64 `json:"hits"`
+	Misses         int64 `json:"misses"`
+	PeerLoads      int64 `json:"peer_loads"`
+	PeerErrors     int64 `json:"peer_errors"`
+	LocalLoads     int64 `json:"local_loads"`
+	LocalLoadErrs  int64 `json:"local_load_errs"`
+	Dedups         int64 `json:"dedups"`
+	StaleHits      int64 `json:"stale_hits"`
+	Refreshes      int64 `json:"refreshes"`
+	PeerRetries    int64 `json:"peer_retries"`
+	BreakerRejects int64 `json:"breaker_rejects"`
+	Hedges         int64 `json:"hedges"`
+	HedgeWins      int64 `json:"hedge_wins"`
+	Pushes         int64 `json:"pushes"`
+	PushErrors     int64 `json:"push_errors"`
+
+	// 下面三项为 mainCache 与 hotCache 之和
+	Evictions int64 `json:"evictions"`
+	Bytes     int64 `json:"bytes"`
+	Items     int64 `json:"items"`
+
+	MainCache CacheStats `json:"main_cache"`
+	HotCache  CacheStats `json:"hot_cache"`
+}
+
+// CacheStats 是单个 cache 统计信息的快照
+type CacheStats struct {
+	Bytes     int64 `json:"bytes"`
+	Items     int64 `json:"items"`
+	Gets      int64 `json:"gets"`
+	Hits      int64 `json:"hits"`
+	Evictions int64 `json:"evictions"` // 因容量不足或过期被淘汰的条数 不含 Remove Set Purge
+}
+
+// HitRatio 返回命中率 还没有请求时返回 0
+func (s Stats) HitRatio() float64 {
+	if s.Gets == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Gets)
+}
+
+// PoolStats 是 HTTPPool 作为服务端的统计信息快照
+type PoolStats struct {
+	ServerRequests int64 `json:"server_requests"` // 收到的取数据请求
+	ServerErrors   int64 `json:"server_errors"`   // 其中处理失败的请求
+	Invalidations  int64 `json:"invalidations"`   // 收到的删除广播
+	RingMismatches int64 `json:"ring_mismatches"` // 发送方节点列表版本与本节点不同的请求
+	Pushes         int64 `json:"pushes"`          // 收到的主节点推送
+}
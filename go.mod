@@ -4,5 +4,6 @@ go 1.13
 
 require (
 	github.com/golang/protobuf v1.4.2
+	google.golang.org/grpc v1.33.2
 	google.golang.org/protobuf v1.25.0
 )
@@ -0,0 +1,117 @@
+package lru
+
+import (
+	"time"
+)
+
+/*
+ARC（Adaptive Replacement Cache）同时维护最近访问和经常访问两个 LRU
+t1 只访问过一次的记录 t2 至少访问过两次的记录
+b1 b2 分别记录最近从 t1 t2 淘汰的 key（幽灵记录 不保存 value）
+再次加入的 key 命中 b1 说明 t1 太小 命中 b2 说明 t2 太小 据此调整 t1 的目标大小 p
+论文 https://www.usenix.org/legacy/events/fast03/tech/full_papers/megiddo/megiddo.pdf
+这里的容量按字节计算 p 和幽灵记录的大小同样以字节为单位
+*/
+
+// ARCCache 是 ARC 淘汰策略的实现
+type ARCCache struct {
+	policyBase
+	p      int64     // t1 的目标字节数
+	t1, t2 segment   // 实际保存的记录
+	b1, b2 ghostList // 最近被淘汰的 key
+}
+
+// NewARC 创建 ARCCache
+func NewARC(maxBytes int64, onEvicted func(string, Value, EvictReason)) *ARCCache {
+	return &ARCCache{policyBase: newPolicyBase(maxBytes, onEvicted)}
+}
+
+// Get 查找 key 命中后移到 t2 的队首
+func (c *ARCCache) Get(key string) (value Value, ok bool) {
+	elem, ok := c.lookup(key)
+	if !ok {
+		return nil, false
+	}
+	c.moveTo(elem, &c.t2)
+	return elem.Value.(*node).value, true
+}
+
+// Add 新增/修改 记录永不过期
+func (c *ARCCache) Add(key string, value Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire 新增/修改 记录在 expire 时刻过期
+func (c *ARCCache) AddWithExpire(key string, value Value, expire time.Time) {
+	if c.reject(key, value) {
+		return
+	}
+	if elem, ok := c.items[key]; ok { // 修改算作再次访问
+		c.update(elem, value, expire)
+		c.moveTo(elem, &c.t2)
+		c.evict(false)
+		return
+	}
+	n := newNode(key, value, expire)
+	switch {
+	case c.b1.remove(key): // t1 太小 增大 p
+		delta := n.size
+		if c.b1.bytes > 0 && c.b2.bytes > c.b1.bytes {
+			delta *= c.b2.bytes / c.b1.bytes
+		}
+		if c.p += delta; c.p > c.maxBytes {
+			c.p = c.maxBytes
+		}
+		c.insert(&c.t2, n)
+		c.evict(false)
+	case c.b2.remove(key): // t2 太小 减小 p
+		delta := n.size
+		if c.b2.bytes > 0 && c.b1.bytes > c.b2.bytes {
+			delta *= c.b1.bytes / c.b2.bytes
+		}
+		if c.p -= delta; c.p < 0 {
+			c.p = 0
+		}
+		c.insert(&c.t2, n)
+		c.evict(true)
+	default:
+		c.insert(&c.t1, n)
+		c.evict(false)
+	}
+	c.trimGhosts()
+}
+
+// 超出容量时 t1 大于目标大小 p 就淘汰 t1 中最旧的 否则淘汰 t2 中最旧的
+// 被淘汰的 key 进入对应的幽灵记录
+func (c *ARCCache) evict(inB2 bool) {
+	for c.full() {
+		if c.t1.ll.Len() > 0 && (c.t1.bytes > c.p || (inB2 && c.t1.bytes == c.p) || c.t2.ll.Len() == 0) {
+			n := c.removeElement(c.t1.ll.Back(), EvictCapacity)
+			c.b1.add(n.key, n.size)
+		} else {
+			n := c.removeElement(c.t2.ll.Back(), EvictCapacity)
+			c.b2.add(n.key, n.size)
+		}
+	}
+}
+
+// 限制幽灵记录的大小 t1+b1 不超过 maxBytes 全部加起来不超过 2*maxBytes
+func (c *ARCCache) trimGhosts() {
+	if c.maxBytes == 0 {
+		return
+	}
+	for c.t1.bytes+c.b1.bytes > c.maxBytes && c.b1.ll.Len() > 0 {
+		c.b1.removeOldest()
+	}
+	for c.nbytes+c.b1.bytes+c.b2.bytes > 2*c.maxBytes && c.b2.ll.Len() > 0 {
+		c.b2.removeOldest()
+	}
+}
+
+// Purge 清空所有记录和幽灵记录 每条记录都会触发一次 OnEvicted
+func (c *ARCCache) Purge() {
+	c.purgeItems()
+	c.b1.purge()
+	c.b2.purge()
+	c.p = 0
+}
@@ -0,0 +1,109 @@
+package lru
+
+import (
+	"container/list"
+	"time"
+)
+
+/*
+LFU 淘汰访问次数最少的记录 访问次数相同时淘汰最久没有访问的
+记录按访问次数放在不同的频率桶中 桶按频率从小到大排列 Get Add 淘汰都是 O(1)
+适合热点长期稳定的场景 缺点是曾经的热点即使不再访问也很难被淘汰
+*/
+
+// lfuBucket 是访问次数为 freq 的所有记录
+type lfuBucket struct {
+	freq int
+	segment
+}
+
+// LFUCache 是 LFU 淘汰策略的实现
+type LFUCache struct {
+	policyBase
+	freqs list.List // 元素为 *lfuBucket 队首频率最小
+}
+
+// NewLFU 创建 LFUCache
+func NewLFU(maxBytes int64, onEvicted func(string, Value, EvictReason)) *LFUCache {
+	return &LFUCache{policyBase: newPolicyBase(maxBytes, onEvicted)}
+}
+
+// Get 查找 key 命中后访问次数加一
+func (c *LFUCache) Get(key string) (value Value, ok bool) {
+	elem, ok := c.lookup(key)
+	if !ok {
+		return nil, false
+	}
+	c.increment(elem)
+	return elem.Value.(*node).value, true
+}
+
+// 把记录移到下一个频率桶 原来的桶空了就删除
+func (c *LFUCache) increment(elem *list.Element) {
+	n := elem.Value.(*node)
+	cur := n.freq
+	b := cur.Value.(*lfuBucket)
+	next := cur.Next()
+	if next == nil || next.Value.(*lfuBucket).freq != b.freq+1 {
+		next = c.freqs.InsertAfter(&lfuBucket{freq: b.freq + 1}, cur)
+	}
+	b.remove(elem)
+	if b.ll.Len() == 0 {
+		c.freqs.Remove(cur)
+	}
+	n.freq = next
+	c.items[n.key] = next.Value.(*lfuBucket).pushFront(n)
+}
+
+// Add 新增/修改 记录永不过期
+func (c *LFUCache) Add(key string, value Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire 新增/修改 记录在 expire 时刻过期 修改同样算一次访问
+// 新增时先淘汰再放入 否则新记录的访问次数最少 总是最先被淘汰
+func (c *LFUCache) AddWithExpire(key string, value Value, expire time.Time) {
+	if c.reject(key, value) {
+		return
+	}
+	if elem, ok := c.items[key]; ok {
+		c.update(elem, value, expire)
+		c.increment(elem)
+		for c.full() {
+			c.evict()
+		}
+		return
+	}
+	n := newNode(key, value, expire)
+	for c.maxBytes != 0 && c.nbytes+n.size > c.maxBytes && len(c.items) > 0 {
+		c.evict()
+	}
+	front := c.freqs.Front()
+	if front == nil || front.Value.(*lfuBucket).freq != 1 {
+		front = c.freqs.PushFront(&lfuBucket{freq: 1})
+	}
+	n.freq = front
+	c.insert(&front.Value.(*lfuBucket).segment, n)
+}
+
+// 淘汰频率最小的桶中最久没有访问的记录
+// Remove 和过期删除可能留下空桶 在这里顺便清理
+func (c *LFUCache) evict() {
+	for front := c.freqs.Front(); front != nil; front = c.freqs.Front() {
+		b := front.Value.(*lfuBucket)
+		if back := b.ll.Back(); back != nil {
+			c.removeElement(back, EvictCapacity)
+			if b.ll.Len() == 0 {
+				c.freqs.Remove(front)
+			}
+			return
+		}
+		c.freqs.Remove(front)
+	}
+}
+
+// Purge 清空所有记录 每条记录都会触发一次 OnEvicted
+func (c *LFUCache) Purge() {
+	c.purgeItems()
+	c.freqs.Init()
+}
@@ -1,6 +1,9 @@
 package lru
 
-import "container/list"
+import (
+	"container/list"
+	"time"
+)
 /*
 test 简明教程 https://geektutu.com/post/quick-go-test.html
 list 官方文档 https://golang.org/pkg/container/list/
@@ -11,12 +14,48 @@ type Cache struct {
 	nbytes int64	// 当前已使用的内存
 	ll  *list.List // 双向链表
 	cache map[string]*list.Element
-	OnEvicted func(key string, value Value) // 某条记录被移除是的回调函数 可为nil
+	// 某条记录被移除是的回调函数 可为nil
+	// 注意 与早期版本不兼容 回调多了 reason 参数 原来的 func(key string, value Value) 需要改为
+	// func(key string, value Value, reason EvictReason) 不关心原因时忽略 reason 即可
+	OnEvicted func(key string, value Value, reason EvictReason)
+}
+
+// EvictReason 表示一条记录被移除的原因 随 OnEvicted 回调一起传出
+type EvictReason int
+
+const (
+	EvictCapacity EvictReason = iota // 超出 maxBytes 被淘汰
+	EvictExpired                     // 已过期
+	EvictRemoved                     // 被 Remove 主动删除
+	EvictReplaced                    // 被同一个 key 的新值覆盖
+	EvictPurged                      // 被 Purge 清空
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictRemoved:
+		return "removed"
+	case EvictReplaced:
+		return "replaced"
+	case EvictPurged:
+		return "purged"
+	}
+	return "unknown"
 }
 
 type entry struct { // 双向链表节点的数据类型
 	key string
 	value Value
+	expire time.Time // 过期时间 零值表示永不过期
+}
+
+// 判断 entry 在 now 时刻是否已经过期
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && !now.Before(e.expire)
 }
 
 type Value interface { //
@@ -24,7 +63,7 @@ type Value interface { //
 }
 
 // 实例化
-func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
+func New(maxBytes int64, onEvicted func(string, Value, EvictReason)) *Cache {
 	return &Cache{
 		maxBytes:maxBytes,
 		ll: list.New(),
@@ -34,10 +73,15 @@ func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
 }
 
 // 查找功能
+// 惰性过期：查到的记录若已过期 则顺手删除 并当作未命中处理
 func (c *Cache) Get(key string) (value Value, ok bool) {
 	if elem, ok := c.cache[key]; ok {
-		c.ll.MoveToFront(elem) // 移到队首
 		kv := elem.Value.(*entry)
+		if kv.expired(time.Now()) {
+			c.removeElement(elem, EvictExpired)
+			return nil, false
+		}
+		c.ll.MoveToFront(elem) // 移到队首
 		return kv.value, true
 	}
 	return
@@ -47,25 +91,79 @@ func (c *Cache) Get(key string) (value Value, ok bool) {
 func (c *Cache) RemoveOldest() {
 	elem := c.ll.Back() // 取出队尾元素
 	if elem != nil {
-		c.ll.Remove(elem) // 将队尾元素从链表中删除
-		kv := elem.Value.(*entry)
-		delete(c.cache, kv.key)  // 删除映射
-		c.nbytes -= (int64((len(kv.key))) + int64(kv.value.Len())) // 更新已使用的内存
-		if c.OnEvicted != nil { // 回调函数
-			c.OnEvicted(kv.key, kv.value)
+		c.removeElement(elem, EvictCapacity)
+	}
+}
+
+// Remove 删除指定的 key 返回 key 是否存在
+func (c *Cache) Remove(key string) bool {
+	if elem, ok := c.cache[key]; ok {
+		c.removeElement(elem, EvictRemoved)
+		return true
+	}
+	return false
+}
+
+// Purge 清空所有记录 每条记录都会触发一次 OnEvicted
+func (c *Cache) Purge() {
+	for elem := c.ll.Back(); elem != nil; elem = c.ll.Back() {
+		c.removeElement(elem, EvictPurged)
+	}
+}
+
+// 从链表和映射中删除 elem 更新内存并触发回调
+func (c *Cache) removeElement(elem *list.Element, reason EvictReason) {
+	c.ll.Remove(elem) // 将元素从链表中删除
+	kv := elem.Value.(*entry)
+	delete(c.cache, kv.key)  // 删除映射
+	c.nbytes -= (int64((len(kv.key))) + int64(kv.value.Len())) // 更新已使用的内存
+	if c.OnEvicted != nil { // 回调函数
+		c.OnEvicted(kv.key, kv.value, reason)
+	}
+}
+
+// RemoveExpired 主动清理所有已过期的记录 返回清理的条数
+// 供后台清理协程定期调用 避免过期但不再被访问的数据一直占用内存
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for elem := c.ll.Back(); elem != nil; {
+		prev := elem.Prev() // 删除前先记下前一个节点
+		if elem.Value.(*entry).expired(now) {
+			c.removeElement(elem, EvictExpired)
+			n++
 		}
+		elem = prev
 	}
+	return n
 }
 
-// 新增/修改
+// 新增/修改 记录永不过期
 func (c *Cache) Add(key string, value Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire 新增/修改 记录在 expire 时刻过期 expire 为零值表示永不过期
+func (c *Cache) AddWithExpire(key string, value Value, expire time.Time) {
+	// 比 maxBytes 还大的记录 淘汰其他记录也放不下 不保存 原来的旧值同样删除
+	if c.maxBytes != 0 && int64(len(key))+int64(value.Len()) > c.maxBytes {
+		if elem, ok := c.cache[key]; ok {
+			c.removeElement(elem, EvictReplaced)
+		}
+		return
+	}
 	if elem, ok := c.cache[key]; ok { // 存在 更新
 		c.ll.MoveToFront(elem) // 移到队首
 		kv := elem.Value.(*entry)
 		c.nbytes += (int64(value.Len()) - int64(kv.value.Len())) // 更新已使用内存 新增多少内存
+		old := kv.value
 		kv.value = value
+		kv.expire = expire
+		if c.OnEvicted != nil { // 旧值被覆盖 同样通知调用方
+			c.OnEvicted(key, old, EvictReplaced)
+		}
 	} else { // 新增
-		elem := c.ll.PushFront(&entry{key,value})
+		elem := c.ll.PushFront(&entry{key, value, expire})
 		c.cache[key] = elem
 		c.nbytes += (int64(len(key)) + int64(value.Len()))
 	}
@@ -79,3 +177,7 @@ func (c *Cache) Len() int { // 列出缓存的条目数  双向链表中的条
 	return c.ll.Len()
 }
 
+// Bytes 返回当前已使用的内存 即所有 key 与 value 的长度之和
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
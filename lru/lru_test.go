@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 )
 
 type String string // 相当于Value
@@ -39,7 +40,7 @@ func TestCache_RemoveOldest(t *testing.T) {
 
 func TestOnEvicted(t *testing.T) {
 	keys := make([]string, 0)
-	callback := func(key string, value Value) {
+	callback := func(key string, value Value, reason EvictReason) {
 		keys = append(keys, key)
 	} // 回调函数作用 将删除的 key 添加到keys 切片中
 	lru := New(int64(10), callback)
@@ -53,4 +54,46 @@ func TestOnEvicted(t *testing.T) {
 	if !reflect.DeepEqual(expect, keys) {
 		t.Fatalf("Call OnEvicted failed, expect keys equals to %s", expect)
 	}
-}
\ No newline at end of file
+}
+func TestAddWithExpire(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.AddWithExpire("key1", String("1234"), time.Now().Add(-time.Second)) // 已过期
+	lru.AddWithExpire("key2", String("5678"), time.Now().Add(time.Hour))
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 1 {
+		t.Fatalf("expired key1 should be removed on Get")
+	}
+	if v, ok := lru.Get("key2"); !ok || string(v.(String)) != "5678" {
+		t.Fatalf("cache hit key2=5678 failed")
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.AddWithExpire("key1", String("1"), time.Now().Add(-time.Second))
+	lru.Add("key2", String("2"))
+	lru.AddWithExpire("key3", String("3"), time.Now().Add(-time.Second))
+	if n := lru.RemoveExpired(); n != 2 || lru.Len() != 1 {
+		t.Fatalf("RemoveExpired removed %d, %d left", n, lru.Len())
+	}
+}
+
+func TestRemove(t *testing.T) {
+	reasons := make(map[string]EvictReason)
+	callback := func(key string, value Value, reason EvictReason) {
+		reasons[key] = reason
+	}
+	lru := New(int64(0), callback)
+	lru.Add("key1", String("1"))
+	lru.Add("key2", String("2"))
+	lru.Add("key2", String("22"))
+	if !lru.Remove("key1") || lru.Remove("key1") {
+		t.Fatalf("Remove key1 failed")
+	}
+	lru.Purge()
+	if lru.Len() != 0 || lru.nbytes != 0 {
+		t.Fatalf("Purge failed, %d left", lru.Len())
+	}
+	if reasons["key1"] != EvictRemoved || reasons["key2"] != EvictPurged {
+		t.Fatalf("unexpected evict reasons %v", reasons)
+	}
+}
@@ -0,0 +1,262 @@
+package lru
+
+import (
+	"container/list"
+	"time"
+)
+
+/*
+可替换的淘汰策略
+Cache 实现的是 LRU 另外还有 LFU ARC 2Q W-TinyLFU 几种实现
+它们都实现 Policy 接口 按 key 与 value 的字节数计算容量 支持过期时间
+不同的访问模式适合不同的策略 可以在 geecache.NewGroup 时通过 WithPolicy 选择
+*/
+
+// Policy 是淘汰策略的通用接口 与 Cache 一样不是并发安全的 由调用方加锁
+type Policy interface {
+	Get(key string) (value Value, ok bool)
+	Add(key string, value Value)
+	AddWithExpire(key string, value Value, expire time.Time)
+	Remove(key string) bool
+	RemoveExpired() int
+	Purge()
+	Len() int
+	Bytes() int64
+	SetOnEvicted(fn func(key string, value Value, reason EvictReason))
+}
+
+// Factory 创建一个允许使用 maxBytes 内存的 Policy maxBytes 为 0 表示不限制
+type Factory func(maxBytes int64, onEvicted func(string, Value, EvictReason)) Policy
+
+// 各个淘汰策略的 Factory
+var (
+	LRU Factory = func(maxBytes int64, onEvicted func(string, Value, EvictReason)) Policy {
+		return New(maxBytes, onEvicted)
+	}
+	LFU Factory = func(maxBytes int64, onEvicted func(string, Value, EvictReason)) Policy {
+		return NewLFU(maxBytes, onEvicted)
+	}
+	ARC Factory = func(maxBytes int64, onEvicted func(string, Value, EvictReason)) Policy {
+		return NewARC(maxBytes, onEvicted)
+	}
+	TwoQueue Factory = func(maxBytes int64, onEvicted func(string, Value, EvictReason)) Policy {
+		return NewTwoQueue(maxBytes, onEvicted)
+	}
+	TinyLFU Factory = func(maxBytes int64, onEvicted func(string, Value, EvictReason)) Policy {
+		return NewTinyLFU(maxBytes, onEvicted)
+	}
+)
+
+// SetOnEvicted 设置记录被移除时的回调 实现 Policy 接口
+func (c *Cache) SetOnEvicted(fn func(key string, value Value, reason EvictReason)) {
+	c.OnEvicted = fn
+}
+
+var _ Policy = (*Cache)(nil)
+var _ Policy = (*LFUCache)(nil)
+var _ Policy = (*ARCCache)(nil)
+var _ Policy = (*TwoQueueCache)(nil)
+var _ Policy = (*TinyLFUCache)(nil)
+
+/* 下面是各个策略共用的部分 */
+
+// node 是 LFU ARC 2Q W-TinyLFU 中链表节点的数据类型
+type node struct {
+	entry
+	size int64         // key 与 value 的字节数 幽灵记录只保留这个值
+	seg  *segment      // 所在的段
+	freq *list.Element // 只有 LFU 使用 所在的频率桶
+}
+
+func newNode(key string, value Value, expire time.Time) *node {
+	return &node{entry: entry{key, value, expire}, size: int64(len(key)) + int64(value.Len())}
+}
+
+// segment 是一段按访问顺序排列的记录 队首为最近访问的 同时统计其中的字节数
+type segment struct {
+	ll    list.List
+	bytes int64
+}
+
+func (s *segment) pushFront(n *node) *list.Element {
+	n.seg = s
+	s.bytes += n.size
+	return s.ll.PushFront(n)
+}
+
+func (s *segment) remove(elem *list.Element) *node {
+	n := s.ll.Remove(elem).(*node)
+	s.bytes -= n.size
+	return n
+}
+
+// policyBase 保存所有记录的映射和内存统计 各个策略在它的基础上管理自己的段
+type policyBase struct {
+	maxBytes  int64
+	nbytes    int64
+	items     map[string]*list.Element
+	onEvicted func(key string, value Value, reason EvictReason)
+}
+
+func newPolicyBase(maxBytes int64, onEvicted func(string, Value, EvictReason)) policyBase {
+	return policyBase{
+		maxBytes:  maxBytes,
+		items:     make(map[string]*list.Element),
+		onEvicted: onEvicted,
+	}
+}
+
+// 查找 key 已过期的记录会被删除 当作未命中处理
+func (b *policyBase) lookup(key string) (*list.Element, bool) {
+	elem, ok := b.items[key]
+	if !ok {
+		return nil, false
+	}
+	if elem.Value.(*node).expired(time.Now()) {
+		b.removeElement(elem, EvictExpired)
+		return nil, false
+	}
+	return elem, true
+}
+
+// 把新记录放到 seg 的队首
+func (b *policyBase) insert(seg *segment, n *node) {
+	b.items[n.key] = seg.pushFront(n)
+	b.nbytes += n.size
+}
+
+// 把记录移到 seg 的队首 seg 可以是它原来所在的段
+func (b *policyBase) moveTo(elem *list.Element, seg *segment) *list.Element {
+	n := elem.Value.(*node)
+	if n.seg == seg {
+		seg.ll.MoveToFront(elem)
+		return elem
+	}
+	n.seg.remove(elem)
+	elem = seg.pushFront(n)
+	b.items[n.key] = elem
+	return elem
+}
+
+// 用新值覆盖已有的记录 旧值通过回调通知调用方
+func (b *policyBase) update(elem *list.Element, value Value, expire time.Time) {
+	n := elem.Value.(*node)
+	old := n.value
+	size := int64(len(n.key)) + int64(value.Len())
+	n.seg.bytes += size - n.size
+	b.nbytes += size - n.size
+	n.size = size
+	n.value = value
+	n.expire = expire
+	if b.onEvicted != nil {
+		b.onEvicted(n.key, old, EvictReplaced)
+	}
+}
+
+// 删除记录 更新内存并触发回调
+func (b *policyBase) removeElement(elem *list.Element, reason EvictReason) *node {
+	n := elem.Value.(*node)
+	n.seg.remove(elem)
+	delete(b.items, n.key)
+	b.nbytes -= n.size
+	if b.onEvicted != nil {
+		b.onEvicted(n.key, n.value, reason)
+	}
+	return n
+}
+
+// 记录比 maxBytes 还大时无论怎么淘汰都放不下 不保存 返回 true
+// key 原来的值已经过时 同样删除
+func (b *policyBase) reject(key string, value Value) bool {
+	if b.maxBytes == 0 || int64(len(key))+int64(value.Len()) <= b.maxBytes {
+		return false
+	}
+	if elem, ok := b.items[key]; ok {
+		b.removeElement(elem, EvictReplaced)
+	}
+	return true
+}
+
+// 是否超出了允许的最大内存
+func (b *policyBase) full() bool {
+	return b.maxBytes != 0 && b.nbytes > b.maxBytes
+}
+
+// Remove 删除指定的 key 返回 key 是否存在
+func (b *policyBase) Remove(key string) bool {
+	if elem, ok := b.items[key]; ok {
+		b.removeElement(elem, EvictRemoved)
+		return true
+	}
+	return false
+}
+
+// RemoveExpired 主动清理所有已过期的记录 返回清理的条数
+func (b *policyBase) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for _, elem := range b.items {
+		if elem.Value.(*node).expired(now) {
+			b.removeElement(elem, EvictExpired)
+			n++
+		}
+	}
+	return n
+}
+
+// 删除所有记录 每条记录都会触发一次回调
+func (b *policyBase) purgeItems() {
+	for _, elem := range b.items {
+		b.removeElement(elem, EvictPurged)
+	}
+}
+
+func (b *policyBase) Len() int {
+	return len(b.items)
+}
+
+func (b *policyBase) Bytes() int64 {
+	return b.nbytes
+}
+
+func (b *policyBase) SetOnEvicted(fn func(key string, value Value, reason EvictReason)) {
+	b.onEvicted = fn
+}
+
+// ghostList 记录最近被淘汰的 key 和大小 不保存 value ARC 和 2Q 用它判断 key 是否刚被淘汰过
+type ghostList struct {
+	segment
+	items map[string]*list.Element
+}
+
+func (g *ghostList) add(key string, size int64) {
+	if g.items == nil {
+		g.items = make(map[string]*list.Element)
+	}
+	if elem, ok := g.items[key]; ok {
+		g.segment.remove(elem)
+	}
+	g.items[key] = g.pushFront(&node{entry: entry{key: key}, size: size})
+}
+
+// 删除 key 返回 key 是否在幽灵记录中
+func (g *ghostList) remove(key string) bool {
+	elem, ok := g.items[key]
+	if ok {
+		g.segment.remove(elem)
+		delete(g.items, key)
+	}
+	return ok
+}
+
+func (g *ghostList) removeOldest() {
+	if elem := g.ll.Back(); elem != nil {
+		g.remove(elem.Value.(*node).key)
+	}
+}
+
+func (g *ghostList) purge() {
+	g.ll.Init()
+	g.bytes = 0
+	g.items = nil
+}
@@ -0,0 +1,168 @@
+package lru
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+var policies = map[string]Factory{
+	"LRU":     LRU,
+	"LFU":     LFU,
+	"ARC":     ARC,
+	"2Q":      TwoQueue,
+	"TinyLFU": TinyLFU,
+}
+
+func TestPolicyBasics(t *testing.T) {
+	for name, newPolicy := range policies {
+		reasons := make(map[EvictReason]int)
+		p := newPolicy(0, func(key string, value Value, reason EvictReason) {
+			reasons[reason]++
+		})
+		p.Add("key1", String("1234"))
+		if v, ok := p.Get("key1"); !ok || string(v.(String)) != "1234" {
+			t.Fatalf("%s: cache hit key1=1234 failed", name)
+		}
+		if _, ok := p.Get("key2"); ok {
+			t.Fatalf("%s: cache miss key2 failed", name)
+		}
+		p.Add("key1", String("12"))
+		if p.Len() != 1 || p.Bytes() != int64(len("key1")+len("12")) || reasons[EvictReplaced] != 1 {
+			t.Fatalf("%s: replace key1 failed, len %d bytes %d", name, p.Len(), p.Bytes())
+		}
+		p.AddWithExpire("key2", String("v2"), time.Now().Add(-time.Second))
+		p.AddWithExpire("key3", String("v3"), time.Now().Add(-time.Second))
+		if _, ok := p.Get("key2"); ok {
+			t.Fatalf("%s: expired key2 should miss", name)
+		}
+		if n := p.RemoveExpired(); n != 1 || reasons[EvictExpired] != 2 {
+			t.Fatalf("%s: expect 1 expired key removed, got %d", name, n)
+		}
+		if !p.Remove("key1") || p.Remove("key1") || p.Len() != 0 || p.Bytes() != 0 {
+			t.Fatalf("%s: remove key1 failed", name)
+		}
+		p.Add("k1", String("v1"))
+		p.Add("k2", String("v2"))
+		p.Purge()
+		if p.Len() != 0 || p.Bytes() != 0 || reasons[EvictPurged] != 2 {
+			t.Fatalf("%s: purge failed", name)
+		}
+	}
+}
+
+func TestPolicyMaxBytes(t *testing.T) {
+	const maxBytes = 100
+	for name, newPolicy := range policies {
+		evicted := 0
+		p := newPolicy(maxBytes, func(key string, value Value, reason EvictReason) {
+			if reason == EvictCapacity {
+				evicted++
+			}
+		})
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("k%d", i%37)
+			if _, ok := p.Get(key); !ok {
+				p.Add(key, String("value"))
+			}
+			if p.Bytes() > maxBytes {
+				t.Fatalf("%s: %d bytes exceeds maxBytes", name, p.Bytes())
+			}
+		}
+		if evicted == 0 {
+			t.Fatalf("%s: expect evictions", name)
+		}
+	}
+}
+
+// 比 maxBytes 还大的记录不保存 也不会挤掉其他记录
+func TestPolicyTooLarge(t *testing.T) {
+	const maxBytes = 10
+	for name, newPolicy := range policies {
+		reasons := make(map[EvictReason]int)
+		p := newPolicy(maxBytes, func(key string, value Value, reason EvictReason) {
+			reasons[reason]++
+		})
+		p.Add("k1", String("v1"))
+		p.Add("big", String("0123456789"))
+		if _, ok := p.Get("big"); ok || p.Bytes() > maxBytes {
+			t.Fatalf("%s: entry larger than maxBytes should be rejected, bytes %d", name, p.Bytes())
+		}
+		if _, ok := p.Get("k1"); !ok || reasons[EvictCapacity] != 0 {
+			t.Fatalf("%s: rejected entry should not evict others", name)
+		}
+		p.Add("k1", String("0123456789"))
+		if _, ok := p.Get("k1"); ok || p.Len() != 0 || reasons[EvictReplaced] != 1 {
+			t.Fatalf("%s: oversized update should remove the old value", name)
+		}
+	}
+}
+
+// 已有的记录变大后仍不超过 maxBytes 淘汰其他记录腾出空间
+func TestPolicyGrow(t *testing.T) {
+	const maxBytes = 100
+	for name, newPolicy := range policies {
+		p := newPolicy(maxBytes, nil)
+		p.Add("a", String("012345678"))
+		p.Add("b", String("012345678"))
+		p.Add("a", String(strings.Repeat("x", 90)))
+		if v, ok := p.Get("a"); !ok || len(v.(String)) != 90 || p.Bytes() > maxBytes {
+			t.Fatalf("%s: expect the grown entry to be kept within maxBytes, bytes %d", name, p.Bytes())
+		}
+		if _, ok := p.Get("b"); ok {
+			t.Fatalf("%s: b should be evicted", name)
+		}
+	}
+}
+
+func TestLFU(t *testing.T) {
+	lfu := NewLFU(int64(len("k1v1")*3), nil)
+	lfu.Add("k1", String("v1"))
+	lfu.Add("k2", String("v2"))
+	lfu.Add("k3", String("v3"))
+	lfu.Get("k1")
+	lfu.Get("k1")
+	lfu.Get("k3")
+	lfu.Add("k4", String("v4")) // k2 访问次数最少
+	if _, ok := lfu.Get("k2"); ok {
+		t.Fatalf("k2 should be evicted")
+	}
+	lfu.Add("k5", String("v5")) // k4 只访问过一次
+	for _, key := range []string{"k1", "k3", "k5"} {
+		if _, ok := lfu.Get(key); !ok {
+			t.Fatalf("%s should be kept", key)
+		}
+	}
+}
+
+// 先反复访问一批热点 再扫描大量只访问一次的 key 热点应该留在缓存中
+func TestScanResistance(t *testing.T) {
+	for _, name := range []string{"ARC", "2Q", "TinyLFU"} {
+		p := policies[name](1000, nil)
+		get := func(key string) bool {
+			if _, ok := p.Get(key); ok {
+				return true
+			}
+			p.Add(key, String("value"))
+			return false
+		}
+		for i := 0; i < 5; i++ {
+			for j := 0; j < 20; j++ {
+				get(fmt.Sprintf("hot%d", j))
+			}
+		}
+		for i := 0; i < 1000; i++ {
+			get(fmt.Sprintf("scan%d", i))
+		}
+		hits := 0
+		for j := 0; j < 20; j++ {
+			if get(fmt.Sprintf("hot%d", j)) {
+				hits++
+			}
+		}
+		if hits < 15 {
+			t.Errorf("%s: expect hot keys to survive the scan, got %d/20 hits", name, hits)
+		}
+	}
+}
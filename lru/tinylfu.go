@@ -0,0 +1,199 @@
+package lru
+
+import (
+	"container/list"
+	"time"
+)
+
+/*
+W-TinyLFU 由一个很小的 LRU 窗口和一个分段 LRU（SLRU）组成
+新记录先进入 window 从 window 淘汰的记录作为候选者 与 probation 中最旧的记录比较访问频率
+频率高的留下 访问频率由 Count-Min Sketch 估计 并定期减半 让过去的热点逐渐冷却
+probation 中的记录再次被访问后进入 protected
+论文 https://arxiv.org/abs/1512.00727
+*/
+
+const (
+	defaultWindowRatio    = 0.01 // window 占总容量的比例
+	defaultProtectedRatio = 0.8  // protected 占 SLRU 的比例
+)
+
+// TinyLFUCache 是 W-TinyLFU 淘汰策略的实现
+type TinyLFUCache struct {
+	policyBase
+	sketch         *cmSketch
+	windowBytes    int64 // window 的目标字节数
+	protectedBytes int64 // protected 的目标字节数
+	window         segment
+	probation      segment
+	protected      segment
+}
+
+// NewTinyLFU 创建 TinyLFUCache
+func NewTinyLFU(maxBytes int64, onEvicted func(string, Value, EvictReason)) *TinyLFUCache {
+	window := int64(float64(maxBytes) * defaultWindowRatio)
+	return &TinyLFUCache{
+		policyBase:     newPolicyBase(maxBytes, onEvicted),
+		sketch:         newCMSketch(maxBytes),
+		windowBytes:    window,
+		protectedBytes: int64(float64(maxBytes-window) * defaultProtectedRatio),
+	}
+}
+
+// Get 查找 key 不论是否命中都记录一次访问
+func (c *TinyLFUCache) Get(key string) (value Value, ok bool) {
+	c.sketch.increment(key)
+	elem, ok := c.lookup(key)
+	if !ok {
+		return nil, false
+	}
+	c.touch(elem)
+	return elem.Value.(*node).value, true
+}
+
+// 命中后调整位置 probation 中的记录进入 protected
+// protected 超出目标大小时 把最旧的记录降回 probation
+func (c *TinyLFUCache) touch(elem *list.Element) {
+	if elem.Value.(*node).seg != &c.probation {
+		elem.Value.(*node).seg.ll.MoveToFront(elem)
+		return
+	}
+	c.moveTo(elem, &c.protected)
+	for c.protected.bytes > c.protectedBytes && c.protected.ll.Len() > 1 {
+		c.moveTo(c.protected.ll.Back(), &c.probation)
+	}
+}
+
+// Add 新增/修改 记录永不过期
+func (c *TinyLFUCache) Add(key string, value Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire 新增/修改 记录在 expire 时刻过期
+func (c *TinyLFUCache) AddWithExpire(key string, value Value, expire time.Time) {
+	if c.reject(key, value) {
+		return
+	}
+	c.sketch.increment(key)
+	if elem, ok := c.items[key]; ok {
+		c.update(elem, value, expire)
+		c.touch(elem)
+	} else {
+		c.insert(&c.window, newNode(key, value, expire))
+	}
+	c.evict()
+}
+
+// window 超出目标大小时 最旧的记录作为候选者进入 probation 与 probation 中最旧的记录竞争
+func (c *TinyLFUCache) evict() {
+	if c.maxBytes == 0 {
+		return
+	}
+	for c.window.bytes > c.windowBytes && c.window.ll.Len() > 0 {
+		c.admit(c.moveTo(c.window.ll.Back(), &c.probation))
+	}
+	for c.full() { // 修改记录变大后仍可能超出 依次从 window probation protected 的末尾淘汰
+		victim := c.window.ll.Back()
+		if victim == nil {
+			victim = c.probation.ll.Back()
+		}
+		if victim == nil {
+			victim = c.protected.ll.Back()
+		}
+		c.removeElement(victim, EvictCapacity)
+	}
+}
+
+// 超出容量时比较候选者和受害者的访问频率 频率低的被淘汰 相同时淘汰候选者
+func (c *TinyLFUCache) admit(candidate *list.Element) {
+	for c.full() {
+		victim := c.probation.ll.Back()
+		if victim == candidate { // probation 中只有候选者
+			victim = c.protected.ll.Back()
+		}
+		if victim == nil {
+			c.removeElement(candidate, EvictCapacity)
+			return
+		}
+		if c.sketch.estimate(candidate.Value.(*node).key) <= c.sketch.estimate(victim.Value.(*node).key) {
+			c.removeElement(candidate, EvictCapacity)
+			return
+		}
+		c.removeElement(victim, EvictCapacity)
+	}
+}
+
+// Purge 清空所有记录 每条记录都会触发一次 OnEvicted 访问频率保留
+func (c *TinyLFUCache) Purge() {
+	c.purgeItems()
+}
+
+// cmSketch 是 4 行的 Count-Min Sketch 估计 key 最近的访问次数
+// 每个计数器最大为 15 总共记录 resetAt 次访问后所有计数器减半
+type cmSketch struct {
+	rows      [4][]uint8
+	mask      uint32
+	additions int
+	resetAt   int
+}
+
+// 每个计数器大约对应 64 字节的缓存 宽度取 2 的幂
+func newCMSketch(maxBytes int64) *cmSketch {
+	want := maxBytes / 64
+	if maxBytes == 0 || want > 1<<20 {
+		want = 1 << 20
+	}
+	width := 16
+	for int64(width) < want {
+		width <<= 1
+	}
+	s := &cmSketch{mask: uint32(width - 1), resetAt: 10 * width}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// FNV-1a 哈希 高低 32 位组合出每一行的下标
+func (s *cmSketch) hash(key string) (uint32, uint32) {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return uint32(h), uint32(h>>32) | 1
+}
+
+func (s *cmSketch) increment(key string) {
+	h1, h2 := s.hash(key)
+	for i := range s.rows {
+		idx := (h1 + uint32(i)*h2) & s.mask
+		if s.rows[i][idx] < 15 {
+			s.rows[i][idx]++
+		}
+	}
+	if s.additions++; s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+// 返回各行计数器的最小值
+func (s *cmSketch) estimate(key string) uint8 {
+	h1, h2 := s.hash(key)
+	min := uint8(15)
+	for i := range s.rows {
+		if v := s.rows[i][(h1+uint32(i)*h2)&s.mask]; v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+func (s *cmSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
@@ -0,0 +1,93 @@
+package lru
+
+import (
+	"container/list"
+	"time"
+)
+
+/*
+2Q 把只访问过一次的记录和被再次访问的记录分开管理
+recent 新加入的记录 frequent 被再次访问过的记录 两者都按 LRU 淘汰
+从 recent 淘汰的 key 记录在 recentEvict 中 短时间内再次加入时直接放入 frequent
+一次性扫描大量 key 只会挤掉 recent 中的记录 不会影响 frequent 中的热点
+*/
+
+const (
+	defaultRecentRatio      = 0.25 // recent 占总容量的比例
+	defaultRecentEvictRatio = 0.5  // recentEvict 最多记住总容量一半的 key
+)
+
+// TwoQueueCache 是 2Q 淘汰策略的实现
+type TwoQueueCache struct {
+	policyBase
+	recentBytes      int64 // recent 的目标字节数
+	recentEvictBytes int64 // recentEvict 最多记住的字节数
+	recent           segment
+	frequent         segment
+	recentEvict      ghostList
+}
+
+// NewTwoQueue 创建 TwoQueueCache
+func NewTwoQueue(maxBytes int64, onEvicted func(string, Value, EvictReason)) *TwoQueueCache {
+	return &TwoQueueCache{
+		policyBase:       newPolicyBase(maxBytes, onEvicted),
+		recentBytes:      int64(float64(maxBytes) * defaultRecentRatio),
+		recentEvictBytes: int64(float64(maxBytes) * defaultRecentEvictRatio),
+	}
+}
+
+// Get 查找 key 命中后移到 frequent 的队首
+func (c *TwoQueueCache) Get(key string) (value Value, ok bool) {
+	elem, ok := c.lookup(key)
+	if !ok {
+		return nil, false
+	}
+	c.moveTo(elem, &c.frequent)
+	return elem.Value.(*node).value, true
+}
+
+// Add 新增/修改 记录永不过期
+func (c *TwoQueueCache) Add(key string, value Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire 新增/修改 记录在 expire 时刻过期
+func (c *TwoQueueCache) AddWithExpire(key string, value Value, expire time.Time) {
+	if c.reject(key, value) {
+		return
+	}
+	if elem, ok := c.items[key]; ok { // 修改算作再次访问
+		c.update(elem, value, expire)
+		c.evict(c.moveTo(elem, &c.frequent))
+		return
+	}
+	n := newNode(key, value, expire)
+	if c.recentEvict.remove(key) { // 刚被淘汰又加入 说明不是一次性的访问
+		c.insert(&c.frequent, n)
+	} else {
+		c.insert(&c.recent, n)
+	}
+	c.evict(nil)
+	for c.recentEvict.bytes > c.recentEvictBytes && c.recentEvict.ll.Len() > 0 {
+		c.recentEvict.removeOldest()
+	}
+}
+
+// 超出容量时 recent 大于目标大小就淘汰 recent 中最旧的 否则淘汰 frequent 中最旧的
+// keep 是刚修改的记录 frequent 中只有它时改为淘汰 recent 中的记录
+func (c *TwoQueueCache) evict(keep *list.Element) {
+	for c.full() {
+		if c.recent.ll.Len() > 0 && (c.recent.bytes > c.recentBytes || c.frequent.ll.Len() == 0 || c.frequent.ll.Back() == keep) {
+			n := c.removeElement(c.recent.ll.Back(), EvictCapacity)
+			c.recentEvict.add(n.key, n.size)
+		} else {
+			c.removeElement(c.frequent.ll.Back(), EvictCapacity)
+		}
+	}
+}
+
+// Purge 清空所有记录和 recentEvict 每条记录都会触发一次 OnEvicted
+func (c *TwoQueueCache) Purge() {
+	c.purgeItems()
+	c.recentEvict.purge()
+}
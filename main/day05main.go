@@ -4,7 +4,9 @@ import (
 	"Cache/geecache"
 	"flag"
 	"fmt"
+	"google.golang.org/grpc"
 	"log"
+	"net"
 	"net/http"
 )
 
@@ -21,7 +23,7 @@ func createGroup() *geecache.Group {
 			if v, ok := db[key]; ok {
 				return []byte(v), nil
 			}
-			return nil, fmt.Errorf("%s not exist", key)
+			return nil, fmt.Errorf("%s not exist: %w", key, geecache.ErrNotFound)
 		}))
 }
 
@@ -34,8 +36,26 @@ func startCacheServer(addr string, addrs []string, gee *geecache.Group) {
 	peers := geecache.NewHTTPPool(addr)
 	peers.Set(addrs...)
 	gee.RegisterPeers(peers)
+	mux := http.NewServeMux()
+	mux.Handle("/_geecache/", peers)
+	mux.Handle("/metrics", geecache.MetricsHandler(peers)) // 供 Prometheus 抓取
 	log.Println("geecache is running at", addr)
-	log.Fatal(http.ListenAndServe(addr[7:], peers))
+	log.Fatal(http.ListenAndServe(addr[7:], mux))
+}
+
+// 使用 gRPC 启动缓存服务器 节点地址不带 http:// 前缀
+func startGRPCCacheServer(addr string, addrs []string, gee *geecache.Group) {
+	peers := geecache.NewGRPCPool(addr, nil)
+	peers.Set(addrs...)
+	gee.RegisterPeers(peers)
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := grpc.NewServer()
+	peers.Register(s)
+	log.Println("geecache is running at", addr, "(grpc)")
+	log.Fatal(s.Serve(lis))
 }
 
 // 启动一个 API 服务 端口9999， 与用户进行交互，用户感知
@@ -67,8 +87,10 @@ func startAPIServer(apiAddr string, gee *geecache.Group) {
 func main() {
 	var port int
 	var api bool
+	var useGRPC bool
 	flag.IntVar(&port, "port", 8001, "Geecache server port")
 	flag.BoolVar(&api, "api", false, "Start a api server?")
+	flag.BoolVar(&useGRPC, "grpc", false, "Use gRPC between cache servers?")
 	flag.Parse()
 
 	apiAddr := "http://localhost:9999"
@@ -88,5 +110,13 @@ func main() {
 		fmt.Println("启动成功，此时 port", port)
 		go startAPIServer(apiAddr, gee)
 	}
+	if useGRPC {
+		grpcAddrs := make([]string, 0, len(addrs))
+		for _, v := range addrs {
+			grpcAddrs = append(grpcAddrs, v[7:]) // 去掉 http://
+		}
+		startGRPCCacheServer(addrMap[port][7:], grpcAddrs, gee)
+		return
+	}
 	startCacheServer(addrMap[port], []string(addrs), gee)
 }